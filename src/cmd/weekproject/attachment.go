@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+)
+
+// Limits on what can be attached to an update.
+const (
+	maxAttachments    = 4
+	maxAttachmentSize = 5 << 20 // bytes
+	maxImagePixels    = 25 * 1000 * 1000
+	thumbSize         = 320 // the longest side of a thumbnail, in pixels
+)
+
+// maxUploadSize is the most an update form can send, which is every attachment at the limit plus some for the rest.
+const maxUploadSize = maxAttachments*maxAttachmentSize + 1<<20
+
+// attachmentTypes are the MIME types which can be attached. The type is sniffed from the contents rather than taken
+// from the browser, so someone can't upload HTML and call it an image.
+var attachmentTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"application/pdf": true,
+	"text/plain":      true,
+}
+
+var errImageTooLarge = errors.New("image is too large")
+
+// Attach stores the uploaded files (and thumbnails of any images) in `blobs` and adds them to the update. Anything the
+// user needs to fix, such as a file which is too big, is set onto the Update.Error field under "Attachments" and
+// nothing is attached. Every file is checked before any are stored, so one which can't be attached doesn't leave the
+// others behind. It only returns an error if the store fails.
+func (u *Update) Attach(blobs BlobStore, files []*multipart.FileHeader) error {
+	if u.Error == nil {
+		u.Error = make(map[string]string)
+	}
+
+	if len(files) > maxAttachments {
+		u.Error["Attachments"] = fmt.Sprintf("You can attach up to %d files", maxAttachments)
+		return nil
+	}
+
+	staged := make([]stagedAttachment, 0, len(files))
+	for _, fh := range files {
+		s, msg, err := readAttachment(fh)
+		if err != nil {
+			return err
+		}
+		if msg != "" {
+			u.Error["Attachments"] = msg
+			return nil
+		}
+		staged = append(staged, s)
+	}
+
+	attachments, added, err := storeAttachments(blobs, staged)
+	if err != nil {
+		return err
+	}
+	u.Attachments = append(u.Attachments, attachments...)
+	u.added = append(u.added, added...)
+	return nil
+}
+
+// Unattach removes the blobs which Attach added to the store, for when the update can't be saved after all. Blobs
+// which were already there are left, since other updates use them.
+func (u *Update) Unattach(blobs BlobStore) {
+	deleteBlobs(blobs, u.added)
+	u.added = nil
+}
+
+// deleteBlobs removes each of these blobs, logging any which can't be removed, since there's nothing more the caller
+// can do about them.
+func deleteBlobs(blobs BlobStore, keys []string) {
+	for _, key := range keys {
+		if err := blobs.Delete(key); err != nil {
+			slog.Error("removing blob", "key", key, "err", err)
+		}
+	}
+}
+
+// stagedAttachment is an uploaded file which has been checked, and it's thumbnail made, but which isn't stored yet.
+type stagedAttachment struct {
+	Attachment
+	data  []byte
+	thumb []byte // only for images
+}
+
+// readAttachment reads and checks one uploaded file. It returns a message for the user if the file can't be attached.
+func readAttachment(fh *multipart.FileHeader) (stagedAttachment, string, error) {
+	name := attachmentName(fh.Filename)
+	tooBig := fmt.Sprintf("%s is too big, files can be up to %d MB", name, maxAttachmentSize>>20)
+
+	if fh.Size > maxAttachmentSize {
+		return stagedAttachment{}, tooBig, nil
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return stagedAttachment{}, "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxAttachmentSize+1))
+	if err != nil {
+		return stagedAttachment{}, "", err
+	}
+	if len(data) > maxAttachmentSize {
+		return stagedAttachment{}, tooBig, nil
+	}
+	if len(data) == 0 {
+		return stagedAttachment{}, name + " is empty", nil
+	}
+
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !attachmentTypes[mimeType] {
+		return stagedAttachment{}, name + " isn't a type of file we accept, which are images, PDFs and plain text", nil
+	}
+
+	s := stagedAttachment{Attachment: Attachment{Name: name, MimeType: mimeType, Size: int64(len(data))}, data: data}
+
+	// make the thumbnail now, so we don't store an image we can't read
+	if strings.HasPrefix(mimeType, "image/") {
+		thumb, width, height, err := makeThumbnail(data)
+		if err == errImageTooLarge {
+			return stagedAttachment{}, name + " has too many pixels, please make it smaller", nil
+		}
+		if err != nil {
+			return stagedAttachment{}, "Sorry, we couldn't read the image " + name, nil
+		}
+		s.Width = width
+		s.Height = height
+		s.thumb = thumb
+	}
+
+	return s, "", nil
+}
+
+// storeAttachments puts the staged files and their thumbnails into the store, returning the keys of those it added. If
+// any of them fails, those which this added are removed again. Those which were already there are left, since other
+// updates may use them.
+func storeAttachments(blobs BlobStore, staged []stagedAttachment) ([]Attachment, []string, error) {
+	added := make([]string, 0)
+	put := func(data []byte) (string, error) {
+		existed := hasBlob(blobs, blobKey(data))
+		key, err := blobs.Put(bytes.NewReader(data))
+		if err == nil && !existed {
+			added = append(added, key)
+		}
+		return key, err
+	}
+
+	attachments := make([]Attachment, 0, len(staged))
+	for _, s := range staged {
+		a := s.Attachment
+
+		var err error
+		if s.thumb != nil {
+			a.Thumb, err = put(s.thumb)
+		}
+		if err == nil {
+			a.Key, err = put(s.data)
+		}
+		if err != nil {
+			deleteBlobs(blobs, added)
+			return nil, nil, err
+		}
+
+		attachments = append(attachments, a)
+	}
+
+	return attachments, added, nil
+}
+
+// attachmentName cleans up the name a browser sent for a file, which may include a path or odd characters.
+func attachmentName(filename string) string {
+	name := path.Base(strings.Replace(filename, "\\", "/", -1))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	if name == "" || name == "." || name == "/" {
+		return "file"
+	}
+	if utf8.RuneCountInString(name) > 100 {
+		name = string([]rune(name)[:100])
+	}
+	return name
+}
+
+// makeThumbnail decodes the image and returns a JPEG of it which fits in thumbSize, along with the size of the
+// original. The size is checked before decoding, so a small file can't claim to be a huge image.
+func makeThumbnail(data []byte) ([]byte, int, int, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, 0, 0, errImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	buf := &bytes.Buffer{}
+	err = jpeg.Encode(buf, scaleDown(img, thumbSize), &jpeg.Options{Quality: 80})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return buf.Bytes(), config.Width, config.Height, nil
+}
+
+// scaleDown returns a copy of `src` whose longest side is at most `max`, averaging each box of pixels which make up
+// one new pixel. Since JPEGs can't be transparent, it's drawn over white.
+func scaleDown(src image.Image, max int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+
+	dw, dh := sw, sh
+	if sw > max || sh > max {
+		if sw >= sh {
+			dw, dh = max, sh*max/sw
+		} else {
+			dw, dh = sw*max/sh, max
+		}
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		sy0, sy1 := dy*sh/dh, (dy+1)*sh/dh
+		if sy1 == sy0 {
+			sy1 = sy0 + 1
+		}
+		for dx := 0; dx < dw; dx++ {
+			sx0, sx1 := dx*sw/dw, (dx+1)*sw/dw
+			if sx1 == sx0 {
+				sx1 = sx0 + 1
+			}
+
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					pr, pg, pb, pa := src.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					bl += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+
+			// the colours are premultiplied, so adding what's left of white puts them over it
+			white := 0xffff - a/n
+			i := dst.PixOffset(dx, dy)
+			dst.Pix[i+0] = uint8((r/n + white) >> 8)
+			dst.Pix[i+1] = uint8((g/n + white) >> 8)
+			dst.Pix[i+2] = uint8((bl/n + white) >> 8)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+
+	return dst
+}
+
+// IsImage says whether the attachment is an image, and so has a thumbnail.
+func (a Attachment) IsImage() bool {
+	return a.Thumb != ""
+}
+
+// Url is where the attachment is served from, with the share token if it's on an unlisted project. Files other than
+// images are downloaded under their own name.
+func (a Attachment) Url(share string) string {
+	q := url.Values{}
+	if !a.IsImage() {
+		q.Set("name", a.Name)
+	}
+	if share != "" {
+		q.Set("share", share)
+	}
+	return blobUrl(a.Key, q)
+}
+
+// ThumbUrl is where the attachment's thumbnail is served from, with the share token if it's on an unlisted project.
+func (a Attachment) ThumbUrl(share string) string {
+	q := url.Values{}
+	if share != "" {
+		q.Set("share", share)
+	}
+	return blobUrl(a.Thumb, q)
+}
+
+func blobUrl(key string, q url.Values) string {
+	if len(q) == 0 {
+		return "/a/" + key
+	}
+	return "/a/" + key + "?" + q.Encode()
+}
+
+// AttachmentList is what the "attachments" template shows, which is an update's attachments along with the share
+// token they're served with.
+type AttachmentList struct {
+	Attachments []Attachment
+	Share       string
+}
+
+// attachmentsOf is for templates, to pass an update's attachments and the share token to the "attachments" template.
+func attachmentsOf(u *Update, share string) AttachmentList {
+	return AttachmentList{u.Attachments, share}
+}
+
+// HumanSize is the size of the file in B, KB or MB.
+func (a Attachment) HumanSize() string {
+	switch {
+	case a.Size < 1<<10:
+		return fmt.Sprintf("%d B", a.Size)
+	case a.Size < 1<<20:
+		return fmt.Sprintf("%.1f KB", float64(a.Size)/(1<<10))
+	default:
+		return fmt.Sprintf("%.1f MB", float64(a.Size)/(1<<20))
+	}
+}
+
+// serveBlob serves the blob named by `{key}` in the URL to anyone who can view a project it's attached to. Since blobs
+// are content-addressed they never change, so one on a public project can be cached by anyone forever. Any others are
+// only cached by the browser and checked each time, so they stop being served as soon as the project is made private
+// or it's share token changes.
+func serveBlob(db *bolt.DB, blobs BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get(":key")
+
+		owners, err := SelBlobOwners(db, key)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		session, _ := sessionStore.Get(r, sessionName)
+		user := getUserFromSession(session)
+		share := r.URL.Query().Get("share")
+
+		canView, public := false, false
+		for _, o := range owners {
+			p, err := GetProject(db, o.UserName, o.ProjectName)
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+			if p.Name == "" || !p.CanView(user, share) {
+				continue
+			}
+			canView = true
+			public = public || p.IsPublic()
+		}
+		if !canView {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		blob, err := blobs.Open(key)
+		if err == ErrNoSuchBlob {
+			renderError(w, r, errNotFound)
+			return
+		}
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		defer blob.Close()
+
+		if public {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "private, no-cache")
+		}
+		w.Header().Set("ETag", `"`+key+`"`)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if name := r.URL.Query().Get("name"); name != "" {
+			w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachmentName(name)}))
+		}
+
+		// with no name, ServeContent sniffs the type from the contents, the same as when it was uploaded
+		http.ServeContent(w, r, "", time.Time{}, blob)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	gcontext "github.com/gorilla/context"
+	"github.com/markbates/goth/gothic"
+)
+
+// serveWith calls `next` with a copy of `r` which has `val` in it's context. The session store keeps it's registry per
+// *http.Request in `gorilla/context`, and the router only clears the request it was given, so we clear the copy too.
+func serveWith(next http.HandlerFunc, w http.ResponseWriter, r *http.Request, key ctxKey, val interface{}) {
+	r2 := r.WithContext(context.WithValue(r.Context(), key, val))
+	defer gcontext.Clear(r2)
+	next(w, r2)
+}
+
+// authProvider is the goth provider people sign in with, which is the `{provider}` in `/auth/{provider}`.
+const authProvider = "twitter"
+
+// authUrl is where to sign in, coming back to `returnTo` afterwards if it's set.
+func authUrl(returnTo string) string {
+	if returnTo == "" {
+		return "/auth/" + authProvider
+	}
+	return "/auth/" + authProvider + "?return_to=" + url.QueryEscape(returnTo)
+}
+
+// loginUrl is where we send people who need to sign in, with a `return_to` so they end up back where they were.
+func loginUrl(r *http.Request) string {
+	returnTo := r.URL.Path
+	if query := userQuery(r); len(query) > 0 {
+		returnTo += "?" + query.Encode()
+	}
+	if r.Method != http.MethodGet {
+		// we can't replay a POST after sign in, so just go somewhere sensible
+		returnTo = "/p/"
+	}
+	return authUrl(returnTo)
+}
+
+// userQuery returns the query params the user actually sent, without the `:name` ones which `pat` adds for the URL
+// params.
+func userQuery(r *http.Request) url.Values {
+	query := url.Values{}
+	for k, v := range r.URL.Query() {
+		if !strings.HasPrefix(k, ":") {
+			query[k] = v
+		}
+	}
+	return query
+}
+
+// safeReturnTo says whether `path` is somewhere on this site we're happy to redirect to after sign in. This stops
+// anyone using our sign in as an open redirect.
+func safeReturnTo(path string) bool {
+	if !strings.HasPrefix(path, "/") {
+		return false
+	}
+	if strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return false
+	}
+	if strings.HasPrefix(path, "/auth/") {
+		return false
+	}
+	return true
+}
+
+// beginAuth remembers any `return_to` in the session, then hands off to gothic to start the sign in.
+func beginAuth(w http.ResponseWriter, r *http.Request) {
+	returnTo := r.URL.Query().Get("return_to")
+	if safeReturnTo(returnTo) {
+		session, _ := sessionStore.Get(r, sessionName)
+		session.Values["return_to"] = returnTo
+		session.Save(r, w)
+	}
+
+	gothic.BeginAuthHandler(w, r)
+}
+
+// popReturnTo removes and returns any `return_to` saved in the session by beginAuth, or `def` if there isn't one. The
+// caller must save the session.
+func popReturnTo(r *http.Request, def string) string {
+	session, _ := sessionStore.Get(r, sessionName)
+	returnTo := getStringFromSession(session, "return_to")
+	delete(session.Values, "return_to")
+	if !safeReturnTo(returnTo) {
+		return def
+	}
+	return returnTo
+}
+
+// requireUser is middleware for pages which need a signed in user. It puts the user into the request context (see
+// userFor), or redirects to sign in if there isn't one.
+func requireUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, _ := sessionStore.Get(r, sessionName)
+		user := getUserFromSession(session)
+		if user == nil {
+			logFor(r).Debug("no user")
+			http.Redirect(w, r, loginUrl(r), http.StatusFound)
+			return
+		}
+
+		serveWith(next, w, r, ctxKeyUser, user)
+	}
+}
+
+// userFor returns the user put into the context by requireUser, or nil.
+func userFor(r *http.Request) *User {
+	user, ok := r.Context().Value(ctxKeyUser).(*User)
+	if !ok {
+		return nil
+	}
+	return user
+}
+
+// loadProject is middleware, to be used inside requireUser, which loads the project named by `{projectName}` in the
+// URL into the request context (see projectFor). The project is looked up under `{userName}` if that's in the URL, or
+// the current user if not. It renders a 404 if there is no such project and a 403 if `allowed` says the user can't
+// touch it, e.g. `(*Project).CanEdit`.
+func loadProject(db *bolt.DB, allowed func(*Project, *User) bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lg := logFor(r)
+		user := userFor(r)
+
+		// get the owner and project name from the URL
+		userName := r.URL.Query().Get(":userName")
+		if userName == "" {
+			userName = user.Name
+		}
+		projectName := r.URL.Query().Get(":projectName")
+
+		// try and retrieve this project from the store
+		p, err := GetProject(db, userName, projectName)
+		if err != nil {
+			lg.Error("getting project", "userName", userName, "projectName", projectName, "err", err)
+			renderError(w, r, err)
+			return
+		}
+		if p.Name == "" {
+			lg.Debug("project not found", "userName", userName, "projectName", projectName)
+			renderError(w, r, errNotFound)
+			return
+		}
+		if !allowed(&p, user) {
+			renderError(w, r, errForbidden)
+			return
+		}
+
+		serveWith(next, w, r, ctxKeyProject, &p)
+	}
+}
+
+// signedIn lets loadProject load any project for any signed in user, for handlers which need to decide for themselves,
+// e.g. with Project.CanView and a share token.
+func signedIn(p *Project, u *User) bool {
+	return u != nil
+}
+
+// projectFor returns the project put into the context by loadProject, or nil.
+func projectFor(r *http.Request) *Project {
+	p, ok := r.Context().Value(ctxKeyProject).(*Project)
+	if !ok {
+		return nil
+	}
+	return p
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	gcontext "github.com/gorilla/context"
+)
+
+func TestSafeReturnTo(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/p/", true},
+		{"/u/chilts/p/learn-go/?share=abc", true},
+		{"/", true},
+		{"", false},
+		{"p/", false},
+		{"../p/", false},
+		{"//evil.com", false},
+		{"//evil.com/p/", false},
+		{"/\\evil.com", false},
+		{"https://evil.com", false},
+		{"javascript:alert(1)", false},
+		{"/auth/twitter", false},
+	}
+
+	for _, tt := range tests {
+		if got := safeReturnTo(tt.path); got != tt.want {
+			t.Errorf("safeReturnTo(%q) = %t, want %t", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPopReturnTo(t *testing.T) {
+	for path, want := range map[string]string{"/u/chilts/": "/u/chilts/", "//evil.com": "/p/", "": "/p/"} {
+		r := httptest.NewRequest("GET", "/auth/twitter/callback", nil)
+		session, _ := sessionStore.Get(r, sessionName)
+		if path != "" {
+			session.Values["return_to"] = path
+		}
+
+		if got := popReturnTo(r, "/p/"); got != want {
+			t.Errorf("popReturnTo with %q = %q, want %q", path, got, want)
+		}
+		if _, ok := session.Values["return_to"]; ok {
+			t.Errorf("return_to %q is still in the session", path)
+		}
+		gcontext.Clear(r)
+	}
+}
+
+func TestLoginUrl(t *testing.T) {
+	r := httptest.NewRequest("GET", "/p/learn-go/edit?:projectName=learn-go&tab=goals", nil)
+	if got, want := loginUrl(r), "/auth/"+authProvider+"?return_to="+url.QueryEscape("/p/learn-go/edit?tab=goals"); got != want {
+		t.Errorf("loginUrl = %q, want %q", got, want)
+	}
+
+	r = httptest.NewRequest("POST", "/p/learn-go/edit", nil)
+	if got, want := loginUrl(r), "/auth/"+authProvider+"?return_to=%2Fp%2F"; got != want {
+		t.Errorf("loginUrl for a POST = %q, want %q", got, want)
+	}
+}
+
+func TestRequireUser(t *testing.T) {
+	r := httptest.NewRequest("GET", "/dashboard", nil)
+	w := httptest.NewRecorder()
+
+	requireUser(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("called the handler without a user")
+	})(w, r)
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != authUrl("/dashboard") {
+		t.Errorf("got %d to %q, want a redirect to sign in", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestLoadProject(t *testing.T) {
+	loadTemplates("../../../templates")
+	db := testDB(t)
+	p := testProject(t, db, "chilts", "Learn Go", VisibilityPublic)
+
+	tests := []struct {
+		name    string
+		user    string
+		owner   string
+		project string
+		status  int
+		loaded  bool
+	}{
+		{"owner", "chilts", "", p.Name, http.StatusOK, true},
+		{"owner, by user name", "chilts", "chilts", p.Name, http.StatusOK, true},
+		{"missing project", "chilts", "", "learn-rust", http.StatusNotFound, false},
+		{"someone else's project", "bob", "chilts", p.Name, http.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := url.Values{":projectName": {tt.project}}
+			if tt.owner != "" {
+				q.Set(":userName", tt.owner)
+			}
+			r := httptest.NewRequest("GET", "/p/"+tt.project+"/edit?"+q.Encode(), nil)
+			r = r.WithContext(context.WithValue(r.Context(), ctxKeyUser, &User{Name: tt.user}))
+			w := httptest.NewRecorder()
+
+			loaded := false
+			loadProject(db, (*Project).CanEdit, func(w http.ResponseWriter, r *http.Request) {
+				loaded = projectFor(r) != nil && projectFor(r).Name == p.Name
+			})(w, r)
+
+			if w.Code != tt.status || loaded != tt.loaded {
+				t.Errorf("status = %d and loaded = %t, want %d and %t", w.Code, loaded, tt.status, tt.loaded)
+			}
+		})
+	}
+}
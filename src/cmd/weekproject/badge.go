@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+)
+
+// how long a badge or widget can be cached before checking whether the project has changed
+const embedMaxAge = 5 * time.Minute
+
+// how many updates the widget shows
+const widgetUpdates = 5
+
+// the longest title a badge shows, after which it's cut short
+const badgeTitleLen = 40
+
+// badgeTmpl is a flat badge in the usual README style, with the project title on the left and progress on the right.
+// Text is escaped with the `html` builtin, which is also right for XML.
+var badgeTmpl = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{ .Width }}" height="20" role="img" aria-label="{{ html .Label }}: {{ .Progress }}%">
+  <title>{{ html .Label }}: {{ .Progress }}%</title>
+  <linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+  <clipPath id="r"><rect width="{{ .Width }}" height="20" rx="3" fill="#fff"/></clipPath>
+  <g clip-path="url(#r)">
+    <rect width="{{ .LabelWidth }}" height="20" fill="#555"/>
+    <rect x="{{ .LabelWidth }}" width="{{ .ValueWidth }}" height="20" fill="{{ .Colour }}"/>
+    <rect width="{{ .Width }}" height="20" fill="url(#s)"/>
+  </g>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+    <text x="{{ .LabelX }}" y="14">{{ html .Label }}</text>
+    <text x="{{ .ValueX }}" y="14">{{ .Progress }}%</text>
+  </g>
+</svg>
+`))
+
+// Badge is what's drawn on a project's badge.
+type Badge struct {
+	Label      string
+	Progress   int
+	Colour     string
+	Width      int
+	LabelWidth int
+	ValueWidth int
+	LabelX     int
+	ValueX     int
+}
+
+// NewBadge lays out the badge for this project. Widths are estimated from the number of chars, since we can't measure
+// the text without the font.
+func NewBadge(p *Project) Badge {
+	label := p.Title
+	if utf8.RuneCountInString(label) > badgeTitleLen {
+		label = string([]rune(label)[:badgeTitleLen-1]) + "…"
+	}
+	value := fmt.Sprintf("%d%%", p.Progress)
+
+	b := Badge{
+		Label:      label,
+		Progress:   p.Progress,
+		Colour:     progressColour(p.Progress),
+		LabelWidth: textWidth(label),
+		ValueWidth: textWidth(value),
+	}
+	b.Width = b.LabelWidth + b.ValueWidth
+	b.LabelX = b.LabelWidth / 2
+	b.ValueX = b.LabelWidth + b.ValueWidth/2
+	return b
+}
+
+// textWidth is roughly how wide `s` is in 11px Verdana, with some padding either side.
+func textWidth(s string) int {
+	return utf8.RuneCountInString(s)*7 + 10
+}
+
+// progressColour goes from red, through orange and yellow, to green once it's done.
+func progressColour(progress int) string {
+	switch {
+	case progress >= 100:
+		return "#4c1"
+	case progress >= 75:
+		return "#a4a61d"
+	case progress >= 50:
+		return "#dfb317"
+	case progress >= 25:
+		return "#fe7d37"
+	default:
+		return "#e05d44"
+	}
+}
+
+// Svg renders the badge.
+func (b Badge) Svg() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := badgeTmpl.Execute(buf, b)
+	return buf.Bytes(), err
+}
+
+// getEmbeddable returns the project named in the URL if it can be embedded, i.e. it's public, or unlisted and the
+// share token is in the URL. A signed in user doesn't make any difference, since what's embedded may be cached and
+// seen by anyone. It returns errNotFound otherwise.
+func getEmbeddable(db *bolt.DB, r *http.Request) (*Project, error) {
+	p, err := GetProject(db, r.URL.Query().Get(":userName"), r.URL.Query().Get(":projectName"))
+	if err != nil {
+		return nil, err
+	}
+	if p.Name == "" || !p.CanView(nil, r.URL.Query().Get("share")) {
+		return nil, errNotFound
+	}
+	return &p, nil
+}
+
+// serveEmbed serves a badge or widget for `p`, which can be cached for a while and then revalidated against when the
+// project last changed. The type is worked out from `name`.
+func serveEmbed(w http.ResponseWriter, r *http.Request, p *Project, name string, body []byte) {
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(embedMaxAge.Seconds())))
+	w.Header().Set("ETag", fmt.Sprintf(`W/"%s-%d-%d"`, name, p.Updated.UnixNano(), p.Progress))
+	http.ServeContent(w, r, name, p.Updated, bytes.NewReader(body))
+}
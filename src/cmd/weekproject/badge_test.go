@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBadgeEscapesTitle(t *testing.T) {
+	p := &Project{Title: `<script>alert("hi")</script> & more`, Progress: 60}
+
+	svg, err := NewBadge(p).Svg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(svg), "<script>") {
+		t.Errorf("badge has the title unescaped:\n%s", svg)
+	}
+	if !strings.Contains(string(svg), "&lt;script&gt;alert(&#34;hi&#34;)&lt;/script&gt; &amp; more") {
+		t.Errorf("badge doesn't have the escaped title:\n%s", svg)
+	}
+
+	// it must still be well formed XML, or it won't be drawn at all
+	d := xml.NewDecoder(strings.NewReader(string(svg)))
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("badge isn't valid XML: %v", err)
+		}
+	}
+}
+
+func TestBadgeShortensTitle(t *testing.T) {
+	p := &Project{Title: strings.Repeat("学", badgeTitleLen+10)}
+
+	b := NewBadge(p)
+	if got := len([]rune(b.Label)); got != badgeTitleLen {
+		t.Errorf("label has %d chars, want %d", got, badgeTitleLen)
+	}
+	if !strings.HasSuffix(b.Label, "…") {
+		t.Errorf("label = %q, want it to end with …", b.Label)
+	}
+}
+
+func TestGetEmbeddable(t *testing.T) {
+	db := testDB(t)
+	public := testProject(t, db, "chilts", "Learn Go", VisibilityPublic)
+	unlisted := testProject(t, db, "chilts", "Learn Rust", VisibilityUnlisted)
+	private := testProject(t, db, "chilts", "Learn Zig", VisibilityPrivate)
+
+	tests := []struct {
+		name    string
+		project string
+		share   string
+		want    bool
+	}{
+		{"public", public.Name, "", true},
+		{"unlisted without a token", unlisted.Name, "", false},
+		{"unlisted with the wrong token", unlisted.Name, "nope", false},
+		{"unlisted with the token", unlisted.Name, unlisted.ShareToken, true},
+		{"private without a token", private.Name, "", false},
+		{"private with another project's token", private.Name, unlisted.ShareToken, false},
+		{"missing", "missing", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/u/chilts/p/"+tt.project+"/badge.svg", nil)
+			q := r.URL.Query()
+			q.Set(":userName", "chilts")
+			q.Set(":projectName", tt.project)
+			if tt.share != "" {
+				q.Set("share", tt.share)
+			}
+			r.URL.RawQuery = q.Encode()
+
+			p, err := getEmbeddable(db, r)
+			if tt.want {
+				if err != nil || p == nil || p.Name != tt.project {
+					t.Errorf("getEmbeddable() = %+v, %v, want %s", p, err, tt.project)
+				}
+				return
+			}
+			if err != errNotFound {
+				t.Errorf("getEmbeddable() = %+v, %v, want errNotFound", p, err)
+			}
+		})
+	}
+}
+
+func TestServeEmbed(t *testing.T) {
+	db := testDB(t)
+	p := testProject(t, db, "chilts", "Learn Go", VisibilityPublic)
+
+	w := httptest.NewRecorder()
+	serveEmbed(w, httptest.NewRequest("GET", "/u/chilts/p/learn-go/badge.svg", nil), p, "badge.svg", []byte("<svg/>"))
+	if w.Code != http.StatusOK || w.Body.String() != "<svg/>" {
+		t.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/svg+xml" {
+		t.Errorf("Content-Type = %q, want image/svg+xml", ct)
+	}
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	// an unchanged project is revalidated without sending it again
+	r := httptest.NewRequest("GET", "/u/chilts/p/learn-go/badge.svg", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	serveEmbed(w, r, p, "badge.svg", []byte("<svg/>"))
+	if w.Code != http.StatusNotModified {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotModified)
+	}
+}
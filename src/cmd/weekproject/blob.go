@@ -0,0 +1,137 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoSuchBlob is returned when a blob isn't in the store, or the key isn't one we could have made.
+var ErrNoSuchBlob = errors.New("no such blob")
+
+// Blob is the contents of a stored file, which can be seeked so it can be served with ranges.
+type Blob interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// BlobStore keeps the contents of attachments. Blobs are content-addressed, so the same file is only ever stored once
+// and a blob never changes once it's stored.
+type BlobStore interface {
+	// Put stores everything read from `r` and returns it's key.
+	Put(r io.Reader) (string, error)
+	// Open returns the blob with this key, or ErrNoSuchBlob.
+	Open(key string) (Blob, error)
+	// Delete removes the blob with this key, if it's there.
+	Delete(key string) error
+}
+
+// blobKey is the key `data` is stored under.
+func blobKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hasBlob says whether the blob with this key is already stored.
+func hasBlob(blobs BlobStore, key string) bool {
+	b, err := blobs.Open(key)
+	if err != nil {
+		return false
+	}
+	b.Close()
+	return true
+}
+
+// DiskBlobStore keeps blobs as files under a directory, named by the hex SHA-256 of their contents and spread over
+// sub-directories named by the first two chars, e.g. `blobs/ab/abcdef...`.
+type DiskBlobStore struct {
+	Dir string
+}
+
+func NewDiskBlobStore(dir string) (*DiskBlobStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskBlobStore{Dir: dir}, nil
+}
+
+func (s *DiskBlobStore) path(key string) string {
+	return filepath.Join(s.Dir, key[:2], key)
+}
+
+func (s *DiskBlobStore) Put(r io.Reader) (string, error) {
+	// write to a temp file first, since we don't know the key until we've read it all
+	tmp, err := os.CreateTemp(s.Dir, "put-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	key := hex.EncodeToString(h.Sum(nil))
+	path := s.path(key)
+	if _, err := os.Stat(path); err == nil {
+		// we already have it
+		return key, nil
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+	return key, os.Rename(tmp.Name(), path)
+}
+
+func (s *DiskBlobStore) Open(key string) (Blob, error) {
+	if !validBlobKey(key) {
+		return nil, ErrNoSuchBlob
+	}
+
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSuchBlob
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *DiskBlobStore) Delete(key string) error {
+	if !validBlobKey(key) {
+		return nil
+	}
+
+	err := os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// validBlobKey says whether `key` is a hex SHA-256, so that it's safe to use in a path.
+func validBlobKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range key {
+		if !('0' <= c && c <= '9') && !('a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
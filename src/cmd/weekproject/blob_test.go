@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// testBlobs opens a new, empty blob store which is removed after the test.
+func testBlobs(t *testing.T) *DiskBlobStore {
+	t.Helper()
+
+	blobs, err := NewDiskBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blobs
+}
+
+// blobFiles is how many blobs are in the store.
+func blobFiles(t *testing.T, blobs *DiskBlobStore) int {
+	t.Helper()
+
+	n := 0
+	err := filepath.Walk(blobs.Dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// testPng is a w x h image, as a PNG.
+func testPng(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		img.Set(x, 0, color.RGBA{200, 100, 0, 255})
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// uploads makes the file headers a browser would send for these files, in order.
+func uploads(t *testing.T, files ...[2]string) []*multipart.FileHeader {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for _, f := range files {
+		w, err := mw.CreateFormFile("Attachments", f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(f[1]))
+	}
+	mw.Close()
+
+	form, err := multipart.NewReader(body, mw.Boundary()).ReadForm(maxUploadSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+	return form.File["Attachments"]
+}
+
+func TestValidBlobKey(t *testing.T) {
+	key := blobKey([]byte("hello"))
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key, true},
+		{"", false},
+		{key[:63], false},
+		{key + "0", false},
+		{strings.ToUpper(key), false},
+		{"../" + key[3:], false},
+		{strings.Repeat("g", 64), false},
+	}
+
+	for _, tt := range tests {
+		if got := validBlobKey(tt.key); got != tt.want {
+			t.Errorf("validBlobKey(%q) = %t, want %t", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestDiskBlobStore(t *testing.T) {
+	blobs := testBlobs(t)
+
+	key, err := blobs.Put(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != blobKey([]byte("hello")) {
+		t.Errorf("key = %q, want the SHA-256 of the contents", key)
+	}
+
+	again, err := blobs.Put(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != key || blobFiles(t, blobs) != 1 {
+		t.Errorf("putting the same contents again gave %q and %d files, want %q and 1", again, blobFiles(t, blobs), key)
+	}
+
+	blob, err := blobs.Open(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	if string(data) != "hello" {
+		t.Errorf("blob = %q, want %q", data, "hello")
+	}
+
+	for _, missing := range []string{"../../etc/passwd", blobKey([]byte("missing"))} {
+		if _, err := blobs.Open(missing); err != ErrNoSuchBlob {
+			t.Errorf("Open(%q) = %v, want ErrNoSuchBlob", missing, err)
+		}
+	}
+
+	if err := blobs.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	if hasBlob(blobs, key) {
+		t.Error("blob is still there after Delete")
+	}
+	if err := blobs.Delete(key); err != nil {
+		t.Errorf("deleting a missing blob = %v, want nil", err)
+	}
+}
+
+func TestAttachmentName(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"photo.png", "photo.png"},
+		{"/home/chilts/photo.png", "photo.png"},
+		{`C:\Users\chilts\photo.png`, "photo.png"},
+		{"say \"hi\".txt", "say hi.txt"},
+		{"tab\there.txt", "tabhere.txt"},
+		{"  spaced.txt  ", "spaced.txt"},
+		{"", "file"},
+		{"/", "file"},
+		{".", "file"},
+		{strings.Repeat("é", 150), strings.Repeat("é", 100)},
+	}
+
+	for _, tt := range tests {
+		if got := attachmentName(tt.filename); got != tt.want {
+			t.Errorf("attachmentName(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestMakeThumbnail(t *testing.T) {
+	thumb, width, height, err := makeThumbnail(testPng(t, 800, 400))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width != 800 || height != 400 {
+		t.Errorf("size = %dx%d, want the original's 800x400", width, height)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpeg" || img.Bounds().Dx() != thumbSize || img.Bounds().Dy() != thumbSize/2 {
+		t.Errorf("thumbnail is a %dx%d %s, want a %dx%d jpeg", img.Bounds().Dx(), img.Bounds().Dy(), format, thumbSize, thumbSize/2)
+	}
+
+	// a GIF header claiming to be 65535x65535, which is only a few bytes but would take 16 GB to decode
+	huge := []byte("GIF89a\xff\xff\xff\xff\x00\x00\x00")
+	if _, _, _, err := makeThumbnail(huge); err != errImageTooLarge {
+		t.Errorf("makeThumbnail(huge) = %v, want errImageTooLarge", err)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	blobs := testBlobs(t)
+
+	u := &Update{}
+	err := u.Attach(blobs, uploads(t, [2]string{"notes.txt", "some notes"}, [2]string{"photo.png", string(testPng(t, 10, 10))}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Error) != 0 || len(u.Attachments) != 2 {
+		t.Fatalf("got %d attachments and errors %v, want 2 and none", len(u.Attachments), u.Error)
+	}
+	if a := u.Attachments[0]; a.Name != "notes.txt" || a.MimeType != "text/plain" || a.Thumb != "" {
+		t.Errorf("text attachment = %+v", a)
+	}
+	if a := u.Attachments[1]; a.MimeType != "image/png" || a.Width != 10 || !hasBlob(blobs, a.Thumb) {
+		t.Errorf("image attachment = %+v, want it's size and a thumbnail", a)
+	}
+	if n := blobFiles(t, blobs); n != 3 {
+		t.Errorf("%d blobs stored, want 3", n)
+	}
+}
+
+func TestAttachStoresNothingWhenAFileIsBad(t *testing.T) {
+	blobs := testBlobs(t)
+
+	u := &Update{}
+	err := u.Attach(blobs, uploads(t, [2]string{"notes.txt", "some notes"}, [2]string{"page.html", "<html><body>hi</body></html>"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Error["Attachments"] == "" {
+		t.Error("no error for the HTML file")
+	}
+	if len(u.Attachments) != 0 || blobFiles(t, blobs) != 0 {
+		t.Errorf("got %d attachments and %d blobs, want none", len(u.Attachments), blobFiles(t, blobs))
+	}
+}
+
+// failingBlobStore fails every Put after the first `ok`.
+type failingBlobStore struct {
+	*DiskBlobStore
+	ok int
+}
+
+func (s *failingBlobStore) Put(r io.Reader) (string, error) {
+	if s.ok == 0 {
+		return "", errors.New("disk full")
+	}
+	s.ok--
+	return s.DiskBlobStore.Put(r)
+}
+
+func TestAttachRemovesBlobsWhenTheStoreFails(t *testing.T) {
+	blobs := testBlobs(t)
+	existing, err := blobs.Put(strings.NewReader("already here"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Update{}
+	files := uploads(t, [2]string{"old.txt", "already here"}, [2]string{"new.txt", "new"}, [2]string{"last.txt", "last"})
+	err = u.Attach(&failingBlobStore{blobs, 2}, files)
+	if err == nil {
+		t.Fatal("no error from the failing store")
+	}
+	if len(u.Attachments) != 0 {
+		t.Errorf("got %d attachments, want none", len(u.Attachments))
+	}
+	if hasBlob(blobs, blobKey([]byte("new"))) {
+		t.Error("the blob added before the failure is still there")
+	}
+	if !hasBlob(blobs, existing) {
+		t.Error("the blob which was already there was removed")
+	}
+}
+
+func TestServeBlob(t *testing.T) {
+	loadTemplates("../../../templates")
+	db := testDB(t)
+	blobs := testBlobs(t)
+
+	attach := func(p *Project, contents string) string {
+		key, err := blobs.Put(strings.NewReader(contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now().UTC()
+		u := Update{Id: randomHex(8), Status: "Done", Inserted: now, Updated: now,
+			Attachments: []Attachment{{Key: key, Name: "notes.txt", MimeType: "text/plain"}}}
+		if err := InsUpdate(db, *p, u); err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	public := testProject(t, db, "chilts", "Learn Go", VisibilityPublic)
+	unlisted := testProject(t, db, "chilts", "Learn Rust", VisibilityUnlisted)
+	private := testProject(t, db, "chilts", "Learn Zig", VisibilityPrivate)
+
+	publicKey := attach(public, "public")
+	unlistedKey := attach(unlisted, "unlisted")
+	privateKey := attach(private, "private")
+	orphan, _ := blobs.Put(strings.NewReader("orphan"))
+
+	tests := []struct {
+		name   string
+		key    string
+		share  string
+		status int
+		cache  string
+	}{
+		{"public", publicKey, "", 200, "public, max-age=31536000, immutable"},
+		{"unlisted with the token", unlistedKey, unlisted.ShareToken, 200, "private, no-cache"},
+		{"unlisted without the token", unlistedKey, "", 404, ""},
+		{"unlisted with the wrong token", unlistedKey, "nope", 404, ""},
+		{"private", privateKey, "", 404, ""},
+		{"not attached to anything", orphan, "", 404, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/a/"+tt.key+"?:key="+tt.key+"&share="+tt.share, nil)
+			w := httptest.NewRecorder()
+
+			serveBlob(db, blobs)(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.cache != "" && w.Header().Get("Cache-Control") != tt.cache {
+				t.Errorf("Cache-Control = %q, want %q", w.Header().Get("Cache-Control"), tt.cache)
+			}
+		})
+	}
+}
+
+func TestUnattach(t *testing.T) {
+	blobs := testBlobs(t)
+	existing, err := blobs.Put(strings.NewReader("already here"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Update{}
+	err = u.Attach(blobs, uploads(t, [2]string{"old.txt", "already here"}, [2]string{"photo.png", string(testPng(t, 10, 10))}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := blobFiles(t, blobs); n != 3 {
+		t.Fatalf("%d blobs stored, want 3", n)
+	}
+
+	// as when the update can't be saved
+	u.Unattach(blobs)
+	if n := blobFiles(t, blobs); n != 1 || !hasBlob(blobs, existing) {
+		t.Errorf("%d blobs left, want just the one which was already there", n)
+	}
+}
+
+func TestRebuildBlobOwners(t *testing.T) {
+	db := testDB(t)
+	p := testProject(t, db, "chilts", "Learn Go", VisibilityPublic)
+
+	key := blobKey([]byte("notes"))
+	now := time.Now().UTC()
+	u := Update{Id: now.Format(idFormat), Status: "Done", Inserted: now, Updated: now,
+		Attachments: []Attachment{{Key: key, Name: "notes.txt", MimeType: "text/plain"}}}
+	if err := InsUpdate(db, *p, u); err != nil {
+		t.Fatal(err)
+	}
+
+	// rebuilding the search index leaves the owners alone
+	if _, err := RebuildIndex(db); err != nil {
+		t.Fatal(err)
+	}
+	owners, err := SelBlobOwners(db, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 1 {
+		t.Fatalf("owners after reindexing = %+v, want chilts/learn-go", owners)
+	}
+
+	// and they can be rebuilt from the updates if they're lost
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("blob"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := RebuildBlobOwners(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owners, err = SelBlobOwners(db, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(owners) != 1 || owners[0].UserName != "chilts" || owners[0].ProjectName != p.Name {
+		t.Errorf("rebuilt %d blobs with owners %+v, want 1 owned by chilts/%s", n, owners, p.Name)
+	}
+}
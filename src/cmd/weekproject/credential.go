@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+// Credentials let us post as someone, so they're only kept in the store while one of their projects has
+// cross-posting turned on, and they're encrypted with a key made from CREDENTIAL_KEY. Until then, the credential
+// from sign in only lives in their session, which is encrypted too.
+
+// credentialKey is set from CREDENTIAL_KEY by main. Without it, credentials can't be kept and cross-posting can't be
+// turned on.
+var credentialKey []byte
+
+var errNoCredentialKey = errors.New("no CREDENTIAL_KEY, so credentials can't be kept")
+
+// setCredentialKey makes the AES-256 key from `secret`, which can be any length. An empty secret means no key.
+func setCredentialKey(secret string) {
+	if secret == "" {
+		credentialKey = nil
+		return
+	}
+	sum := sha256.Sum256([]byte(secret))
+	credentialKey = sum[:]
+}
+
+// sealedCredential is how a Credential is kept in the store, with the token and secret encrypted together.
+type sealedCredential struct {
+	Provider string
+	Sealed   []byte // the nonce followed by the encrypted JSON of the Credential
+	Updated  time.Time
+}
+
+func credentialAead() (cipher.AEAD, error) {
+	if credentialKey == nil {
+		return nil, errNoCredentialKey
+	}
+	block, err := aes.NewCipher(credentialKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealCredential encrypts the credential for this user. The user's name and the provider are authenticated along
+// with it, so a credential can't be moved to someone else.
+func sealCredential(userName string, c Credential) (sealedCredential, error) {
+	aead, err := credentialAead()
+	if err != nil {
+		return sealedCredential{}, err
+	}
+
+	plain, err := json.Marshal(c)
+	if err != nil {
+		return sealedCredential{}, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return sealedCredential{}, err
+	}
+
+	return sealedCredential{
+		Provider: c.Provider,
+		Sealed:   aead.Seal(nonce, nonce, plain, []byte(userName+"/"+c.Provider)),
+		Updated:  c.Updated,
+	}, nil
+}
+
+// openCredential decrypts a credential sealed for this user.
+func openCredential(userName string, sc sealedCredential) (Credential, error) {
+	c := Credential{}
+
+	aead, err := credentialAead()
+	if err != nil {
+		return c, err
+	}
+	if len(sc.Sealed) < aead.NonceSize() {
+		return c, errors.New("sealed credential is too short")
+	}
+
+	nonce, sealed := sc.Sealed[:aead.NonceSize()], sc.Sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, []byte(userName+"/"+sc.Provider))
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(plain, &c)
+	return c, err
+}
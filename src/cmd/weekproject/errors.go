@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// AppError is an error to be shown to the user as an error page. The Message is safe to show to anyone, whereas the
+// underlying Err (e.g. from the store) is only ever logged.
+type AppError struct {
+	Status  int
+	Message string
+	Err     error
+}
+
+func (e *AppError) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.Status) + " : " + e.Message
+	}
+	return http.StatusText(e.Status) + " : " + e.Message + " : " + e.Err.Error()
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	errNotFound  = &AppError{Status: http.StatusNotFound, Message: "Sorry, we couldn't find that page."}
+	errForbidden = &AppError{Status: http.StatusForbidden, Message: "Sorry, you're not allowed to do that."}
+)
+
+// internalError wraps an unexpected error so that the user just sees a generic message.
+func internalError(err error) *AppError {
+	return &AppError{
+		Status:  http.StatusInternalServerError,
+		Message: "Sorry, something went wrong. Please try again later.",
+		Err:     err,
+	}
+}
+
+// badRequest is for when the request itself can't be understood, such as a form which won't decode.
+func badRequest(msg string, err error) *AppError {
+	return &AppError{
+		Status:  http.StatusBadRequest,
+		Message: msg,
+		Err:     err,
+	}
+}
+
+// errorTemplate returns which error page to render for this status. Anything other than a 403 or 404 gets the 500
+// page, which shows the status anyway.
+func errorTemplate(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return "error-404.html"
+	case http.StatusForbidden:
+		return "error-403.html"
+	}
+	return "error-500.html"
+}
+
+// renderError renders the themed error page for `err`. Any error which isn't an *AppError is treated as an internal
+// one, so it's details are logged but never shown.
+func renderError(w http.ResponseWriter, r *http.Request, err error) {
+	var appErr *AppError
+	if !errors.As(err, &appErr) {
+		appErr = internalError(err)
+	}
+
+	lg := logFor(r)
+	if appErr.Status >= 500 {
+		lg.Error("error page", "status", appErr.Status, "err", err)
+	} else {
+		lg.Debug("error page", "status", appErr.Status, "err", err)
+	}
+
+	user := userFor(r)
+	if user == nil {
+		session, _ := sessionStore.Get(r, sessionName)
+		user = getUserFromSession(session)
+	}
+
+	renderErrorPage(w, appErr, user)
+}
+
+// renderErrorPage does the actual rendering of the error page, falling back to plain text if even that fails.
+func renderErrorPage(w http.ResponseWriter, appErr *AppError, user *User) {
+	data := struct {
+		Title    string
+		SubTitle string
+		User     *User
+		Status   int
+		Message  string
+	}{
+		http.StatusText(appErr.Status),
+		"",
+		user,
+		appErr.Status,
+		appErr.Message,
+	}
+
+	buf := &bytes.Buffer{}
+	errExec := tmpl.ExecuteTemplate(buf, errorTemplate(appErr.Status), data)
+	if errExec != nil {
+		slog.Error("rendering error page", "status", appErr.Status, "err", errExec)
+		http.Error(w, appErr.Message, appErr.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(appErr.Status)
+	buf.WriteTo(w)
+}
+
+// notFound is used for any path which isn't matched by the router.
+func notFound(w http.ResponseWriter, r *http.Request) {
+	renderError(w, r, errNotFound)
+}
+
+// recoverer is middleware which catches any panic in a handler, logs it along with the stack, and renders the 500
+// page instead of dropping the connection.
+func recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				logFor(r).Error("panic", "panic", rec, "stack", string(debug.Stack()))
+				renderErrorPage(w, internalError(nil), nil)
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
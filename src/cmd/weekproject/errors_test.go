@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the default logger to a buffer for the rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	old := slog.Default()
+	slog.SetDefault(newLogger(buf, "debug", "json"))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return buf
+}
+
+func TestRenderError(t *testing.T) {
+	loadTemplates("../../../templates")
+
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   []string // in the page
+		hidden string   // never in the page
+		level  string
+	}{
+		{"not found", errNotFound, http.StatusNotFound, []string{"couldn&#39;t find that page", "The page may have moved"}, "", "DEBUG"},
+		{"forbidden", errForbidden, http.StatusForbidden, []string{"not allowed to do that", "sign in"}, "", "DEBUG"},
+		{"bad request", badRequest("Sorry, we couldn't read that form.", errors.New("schema: invalid path")), http.StatusBadRequest, []string{"read that form", "If this keeps happening"}, "schema", "DEBUG"},
+		{"wrapped", fmt.Errorf("loading: %w", errNotFound), http.StatusNotFound, []string{"The page may have moved"}, "", "DEBUG"},
+		{"internal", errors.New("bolt: database not open"), http.StatusInternalServerError, []string{"something went wrong", "If this keeps happening"}, "bolt", "ERROR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			w := httptest.NewRecorder()
+
+			renderError(w, httptest.NewRequest("GET", "/somewhere", nil), tt.err)
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+				t.Errorf("Content-Type = %q, want the themed page", ct)
+			}
+			for _, s := range tt.body {
+				if !strings.Contains(w.Body.String(), s) {
+					t.Errorf("no %q in the page:\n%s", s, w.Body.String())
+				}
+			}
+			if tt.hidden != "" && strings.Contains(w.Body.String(), tt.hidden) {
+				t.Errorf("the page shows the underlying error %q", tt.hidden)
+			}
+			if !strings.Contains(logs.String(), `"level":"`+tt.level+`"`) || !strings.Contains(logs.String(), tt.err.Error()) {
+				t.Errorf("want the error logged at %s, got: %s", tt.level, logs.String())
+			}
+		})
+	}
+}
+
+func TestRecoverer(t *testing.T) {
+	loadTemplates("../../../templates")
+	logs := captureLogs(t)
+
+	h := recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "If this keeps happening") {
+		t.Errorf("not the themed 500 page:\n%s", w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "boom") {
+		t.Error("the page shows the panic")
+	}
+	for _, s := range []string{`"level":"ERROR"`, `"panic":"boom"`, `"stack":"goroutine`} {
+		if !strings.Contains(logs.String(), s) {
+			t.Errorf("no %s in the log: %s", s, logs.String())
+		}
+	}
+
+	// a handler aborting on purpose is left to net/http
+	defer func() {
+		if rec := recover(); rec != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler passed on", rec)
+		}
+	}()
+	abort := recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	abort.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
@@ -1,8 +1,151 @@
 package main
 
-import "net/http"
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
 
 func toSlash(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path + "/"
 	http.Redirect(w, r, path, http.StatusFound)
 }
+
+// viewUrl is the public URL of the project, keeping any share token the viewer used to get there.
+func viewUrl(p *Project, share string) string {
+	if share == "" {
+		return p.Url()
+	}
+	return p.Url() + "?share=" + url.QueryEscape(share)
+}
+
+// getCloneable returns the public project named by `ref` ("<userName>/<projectName>"), or nil if there isn't one,
+// since only public projects can be cloned.
+func getCloneable(db *bolt.DB, ref string) (*Project, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || strings.Contains(ref, ".") {
+		return nil, nil
+	}
+
+	p, err := GetProject(db, parts[0], parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if p.Name == "" || !p.IsPublic() {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+// publicProject is a project's public page, which anyone who can view the project sees, whether they're signed in or
+// not.
+func publicProject(db *bolt.DB, baseUrl string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// get the names from the URL
+		userName := r.URL.Query().Get(":userName")
+		projectName := r.URL.Query().Get(":projectName")
+
+		logFor(r).Debug("public project", "userName", userName, "projectName", projectName)
+
+		// try and retrieve this project from the store
+		p, err := GetProject(db, userName, projectName)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		if p.Name == "" {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		// now check to see if a user is logged in
+		session, _ := sessionStore.Get(r, sessionName)
+		user := getUserFromSession(session)
+
+		// private and unlisted projects look just like missing ones to anyone who can't see them
+		if !p.CanView(user, r.URL.Query().Get("share")) {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		// get a list of updates
+		updates, bad, err := SelUpdates(db, userName, projectName)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		logBadRecords(logFor(r), bad)
+
+		// and the comments, grouped by the update they're on (or "" for the project itself)
+		comments, bad, err := SelComments(db, userName, projectName)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		logBadRecords(logFor(r), bad)
+		commentsOn := make(map[string][]*Comment)
+		for _, c := range comments {
+			commentsOn[c.UpdateId] = append(commentsOn[c.UpdateId], c)
+		}
+
+		// which updates this user has given kudos to
+		kudos := make(map[string]bool)
+		if user != nil {
+			kudos, err = SelKudosBy(db, p, user.Name)
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+		}
+
+		data := struct {
+			Title        string
+			SubTitle     string
+			User         *User
+			Project      *Project
+			Updates      []*Update
+			Contributors []Contributor
+			CanUpdate    bool
+			Comments     map[string][]*Comment
+			CanComment   bool
+			CanModerate  bool
+			Share        string
+			Kudos        map[string]bool
+			Meta         *Meta
+		}{
+			p.Title,
+			"by @" + p.UserName,
+			user,
+			&p,
+			updates,
+			p.Contributors(updates),
+			p.CanUpdate(user),
+			commentsOn,
+			user != nil && !p.CommentsDisabled,
+			p.CanEdit(user),
+			r.URL.Query().Get("share"),
+			kudos,
+			ProjectMeta(&p, baseUrl),
+		}
+		render(w, "u-user-p-project.html", data)
+	}
+}
+
+// crossPosting says whether any of this user's projects have cross-posting turned on, which is when we keep their
+// credential.
+func crossPosting(db *bolt.DB, userName string) (bool, error) {
+	projects, bad, err := SelProjects(db, userName)
+	if err != nil {
+		return false, err
+	}
+	logBadRecords(slog.Default(), bad)
+	for _, p := range projects {
+		if p.CrossPost {
+			return true, nil
+		}
+	}
+	return false, nil
+}
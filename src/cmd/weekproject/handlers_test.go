@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPublicProjectSignedOut(t *testing.T) {
+	loadTemplates("../../../templates")
+	db := testDB(t)
+
+	public := testProject(t, db, "chilts", "Learn Go", VisibilityPublic)
+	private := testProject(t, db, "chilts", "Learn Rust", VisibilityPrivate)
+
+	tests := []struct {
+		name    string
+		project *Project
+		status  int
+		body    string
+	}{
+		{"public", public, http.StatusOK, `<a href="/auth/twitter?return_to=%2Fu%2Fchilts%2Fp%2Flearn-go%2F">Sign in</a> to leave a comment.`},
+		{"private", private, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// pat passes the route's params in the query
+			q := url.Values{":userName": {tt.project.UserName}, ":projectName": {tt.project.Name}}
+			r := httptest.NewRequest("GET", tt.project.Url()+"?"+q.Encode(), nil)
+			w := httptest.NewRecorder()
+
+			publicProject(db, "https://example.com")(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if !strings.Contains(w.Body.String(), tt.body) {
+				t.Errorf("body doesn't contain %q", tt.body)
+			}
+		})
+	}
+}
+
+func TestGetCloneable(t *testing.T) {
+	db := testDB(t)
+	public := testProject(t, db, "chilts", "Learn Go", VisibilityPublic)
+	unlisted := testProject(t, db, "chilts", "Learn Rust", VisibilityUnlisted)
+	private := testProject(t, db, "chilts", "Learn Zig", VisibilityPrivate)
+
+	tests := []struct {
+		ref  string
+		want bool
+	}{
+		{"chilts/" + public.Name, true},
+		{"chilts/" + unlisted.Name, false},
+		{"chilts/" + private.Name, false},
+		{"chilts/missing", false},
+		{"chilts", false},
+		{"", false},
+		{"chilts/learn-go.update", false},
+	}
+
+	for _, tt := range tests {
+		p, err := getCloneable(db, tt.ref)
+		if err != nil {
+			t.Fatalf("getCloneable(%q): %v", tt.ref, err)
+		}
+		if (p != nil) != tt.want {
+			t.Errorf("getCloneable(%q) = %+v, want cloneable = %t", tt.ref, p, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/boltdb/bolt"
+)
+
+// healthz says the process is up and serving requests, nothing more.
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// draining is set once we've started shutting down, so that readyz tells the load balancer to stop sending us
+// requests while the in-flight ones finish.
+var draining atomic.Bool
+
+// readyz says whether we're able to serve real pages, i.e. we're not shutting down, the store can be read and the
+// templates have been parsed.
+func readyz(db *bolt.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		if draining.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		if tmpl == nil || tmpl.Lookup("index.html") == nil {
+			http.Error(w, "templates not parsed", http.StatusServiceUnavailable)
+			return
+		}
+
+		err := db.View(func(tx *bolt.Tx) error {
+			return nil
+		})
+		if err != nil {
+			logFor(r).Error("readyz", "err", err)
+			http.Error(w, "store not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok\n"))
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadyz(t *testing.T) {
+	loadTemplates("../../../templates")
+	db := testDB(t)
+
+	ready := func() int {
+		w := httptest.NewRecorder()
+		readyz(db)(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+
+	if status := ready(); status != http.StatusOK {
+		t.Errorf("status = %d when ready, want 200", status)
+	}
+
+	draining.Store(true)
+	if status := ready(); status != http.StatusServiceUnavailable {
+		t.Errorf("status = %d while draining, want 503", status)
+	}
+	draining.Store(false)
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if status := ready(); status != http.StatusServiceUnavailable {
+		t.Errorf("status = %d with the store closed, want 503", status)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	healthz(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("got %d %q, want 200 ok", w.Code, w.Body.String())
+	}
+}
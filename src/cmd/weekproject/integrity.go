@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// CheckIntegrity walks every `user.*` bucket (and the mail queue) in the store and returns a record error for anything it can't make sense
+// of, such as JSON which won't decode into the type we expect, or an `.update` bucket belonging to a project which has
+// no meta record. It only ever reads from the store.
+func CheckIntegrity(db *bolt.DB) ([]*RecordError, error) {
+	bad := make([]*RecordError, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		bad = append(bad, checkValues(tx.Bucket([]byte("mail")), "mail", func() interface{} { return &QueuedMail{} })...)
+		bad = append(bad, checkValues(tx.Bucket([]byte("mailfailed")), "mailfailed", func() interface{} { return &QueuedMail{} })...)
+
+		users := tx.Bucket([]byte("user"))
+		if users == nil {
+			return nil
+		}
+
+		return users.ForEach(func(userName, v []byte) error {
+			location := "user." + string(userName)
+			if v != nil {
+				bad = append(bad, newRecordError("user", string(userName), ErrExpectedBucket))
+				return nil
+			}
+
+			ub := users.Bucket(userName)
+			u := User{}
+			bad = append(bad, checkJson(location, "meta", ub.Get([]byte("meta")), &u)...)
+			if prefs := ub.Get([]byte("prefs")); prefs != nil {
+				bad = append(bad, checkJson(location, "prefs", prefs, &Prefs{})...)
+			}
+			bad = append(bad, checkValues(ub.Bucket([]byte("member")), location+".member", func() interface{} { return &Membership{} })...)
+			bad = append(bad, checkValues(ub.Bucket([]byte("following")), location+".following", func() interface{} { return &Follow{} })...)
+			bad = append(bad, checkValues(ub.Bucket([]byte("follower")), location+".follower", func() interface{} { return &Follow{} })...)
+			bad = append(bad, checkValues(ub.Bucket([]byte("webhook")), location+".webhook", func() interface{} { return &Webhook{} })...)
+			bad = append(bad, checkValues(ub.Bucket([]byte("delivery")), location+".delivery", func() interface{} { return &Delivery{} })...)
+			bad = append(bad, checkValues(ub.Bucket([]byte("credential")), location+".credential", func() interface{} { return &sealedCredential{} })...)
+
+			pb := ub.Bucket([]byte("project"))
+			if pb == nil {
+				return nil
+			}
+
+			return pb.ForEach(func(projectName, v []byte) error {
+				bad = append(bad, checkProject(pb, location+".project", projectName)...)
+				return nil
+			})
+		})
+	})
+
+	return bad, err
+}
+
+// checkProject checks the project bucket `name` within `pb`, along with all of it's updates, comments, kudos and
+// cross-posts.
+func checkProject(pb *bolt.Bucket, location string, name []byte) []*RecordError {
+	bad := make([]*RecordError, 0)
+
+	b := pb.Bucket(name)
+	if b == nil {
+		return append(bad, newRecordError(location, string(name), ErrExpectedBucket))
+	}
+	location = location + "." + string(name)
+
+	updates := b.Bucket([]byte("update"))
+	meta := b.Get([]byte("meta"))
+	if meta == nil {
+		if updates != nil {
+			return append(bad, newRecordError(location, "update", ErrOrphanedUpdates))
+		}
+		return append(bad, newRecordError(location, "meta", ErrMissingMeta))
+	}
+
+	p := Project{}
+	bad = append(bad, checkJson(location, "meta", meta, &p)...)
+
+	bad = append(bad, checkValues(updates, location+".update", func() interface{} { return &Update{} })...)
+	bad = append(bad, checkValues(b.Bucket([]byte("comment")), location+".comment", func() interface{} { return &Comment{} })...)
+	bad = append(bad, checkValues(b.Bucket([]byte("kudos")), location+".kudos", func() interface{} { return &time.Time{} })...)
+	bad = append(bad, checkValues(b.Bucket([]byte("socialpost")), location+".socialpost", func() interface{} { return &SocialPost{} })...)
+
+	return bad
+}
+
+// checkValues checks that every value in the bucket `b` (which may be nil) decodes into whatever `newItem` returns.
+func checkValues(b *bolt.Bucket, location string, newItem func() interface{}) []*RecordError {
+	bad := make([]*RecordError, 0)
+	if b == nil {
+		return bad
+	}
+
+	b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			bad = append(bad, newRecordError(location, string(k), ErrExpectedValue))
+			return nil
+		}
+		bad = append(bad, checkJson(location, string(k), v, newItem())...)
+		return nil
+	})
+
+	return bad
+}
+
+// checkJson returns a record error if `raw` is missing or doesn't decode into `v`.
+func checkJson(location, key string, raw []byte, v interface{}) []*RecordError {
+	if raw == nil {
+		return []*RecordError{newRecordError(location, key, ErrMissingMeta)}
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return []*RecordError{newRecordError(location, key, err)}
+	}
+	return nil
+}
+
+// integrityCmd runs the integrity check and writes a report to `w`. It returns an error if any problems were found, so
+// the process exits non-zero.
+func integrityCmd(db *bolt.DB, w io.Writer) error {
+	bad, err := CheckIntegrity(db)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range bad {
+		fmt.Fprintf(w, "%s\n", e.Error())
+	}
+
+	if len(bad) > 0 {
+		return fmt.Errorf("integrity check found %d problem(s)", len(bad))
+	}
+
+	fmt.Fprintf(w, "integrity check ok\n")
+	return nil
+}
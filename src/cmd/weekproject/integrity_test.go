@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCheckIntegrity(t *testing.T) {
+	db := testDB(t)
+	testUser(t, db, "bob")
+	testProject(t, db, "bob", "Learn Go", VisibilityPublic)
+
+	out := &bytes.Buffer{}
+	if err := integrityCmd(db, out); err != nil {
+		t.Fatalf("a good store: err = %v, report = %q", err, out.String())
+	}
+	if out.String() != "integrity check ok\n" {
+		t.Errorf("report = %q", out.String())
+	}
+
+	putRaw(t, db, "user.bob.project.bad-json", "meta", "{not json")
+	putRaw(t, db, "user.bob.project.orphaned.update", "2026-10-19T12:00:00.000000000Z", "{}")
+	putRaw(t, db, "user.bob.project.learn-go.comment", "1", "[]")
+
+	bad, err := CheckIntegrity(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"user.bob.project.bad-json:meta":      true,
+		"user.bob.project.orphaned:update":    true,
+		"user.bob.project.learn-go.comment:1": true,
+	}
+	if len(bad) != len(want) {
+		t.Errorf("got %d problems, want %d: %v", len(bad), len(want), bad)
+	}
+	for _, e := range bad {
+		if !want[e.Location+":"+e.Key] {
+			t.Errorf("unexpected problem %v", e)
+		}
+		if e.Key == "update" && e.Err != ErrOrphanedUpdates {
+			t.Errorf("orphaned updates: err = %v", e.Err)
+		}
+	}
+
+	out.Reset()
+	err = integrityCmd(db, out)
+	if err == nil || !strings.Contains(err.Error(), "3 problem") {
+		t.Errorf("err = %v, want it to count the 3 problems", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 3 {
+		t.Errorf("report = %q, want a line for each problem", out.String())
+	}
+}
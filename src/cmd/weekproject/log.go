@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gomiddleware/logger"
+)
+
+type ctxKey int
+
+const (
+	ctxKeyLogger ctxKey = iota
+	ctxKeyRequestId
+	ctxKeyUser
+	ctxKeyProject
+)
+
+// redacted is what we replace secrets and email addresses with when logging.
+const redacted = "[redacted]"
+
+var emailRegExp = regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`)
+
+// secretKeys are (lowercased) substrings of attribute keys whose values we never log.
+var secretKeys = []string{"token", "secret", "password", "email", "cookie", "authorization"}
+
+// newLogger creates the app's structured logger writing to `w`. The `level` is one of "debug", "info", "warn" or
+// "error" (default "info") and `format` is either "json" or "text" (default "text"). Both come from the LOG_LEVEL and
+// LOG_FORMAT env vars.
+func newLogger(w io.Writer, level, format string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       lvl,
+		ReplaceAttr: redact,
+	}
+
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// redact is used as the ReplaceAttr for our log handlers. It removes the values of any attributes which sound like
+// secrets, and scrubs email addresses out of every string and error, including the message itself. Attributes in
+// groups are passed in one at a time, so they're redacted the same.
+func redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+
+	if a.Value.Kind() == slog.KindString {
+		return slog.String(a.Key, emailRegExp.ReplaceAllString(a.Value.String(), redacted))
+	}
+
+	// errors often quote what they failed on, e.g. a mail server rejecting an address
+	if a.Value.Kind() == slog.KindAny {
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, emailRegExp.ReplaceAllString(err.Error(), redacted))
+		}
+	}
+
+	return a
+}
+
+// randomHex returns `n` random bytes as a hex string, or an empty string if the system's random source fails.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// newRequestId returns a random 16 char hex string.
+func newRequestId() string {
+	id := randomHex(8)
+	if id == "" {
+		return "-"
+	}
+	return id
+}
+
+// requestId is middleware which gives every request an id, returns it in the `X-Request-Id` header, and puts a logger
+// tagged with it into the request's context. It then hands off to the `gomiddleware/logger` middleware using that same
+// logger, so the access log lines are tagged too.
+func requestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newRequestId()
+		w.Header().Set("X-Request-Id", id)
+
+		lg := slog.Default().With("req", id)
+		ctx := context.WithValue(r.Context(), ctxKeyRequestId, id)
+		ctx = context.WithValue(ctx, ctxKeyLogger, lg)
+
+		access := logger.NewLogger(slog.NewLogLogger(lg.Handler(), slog.LevelInfo))
+		access(next).ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// logFor returns the logger for this request, or the default logger if there isn't one.
+func logFor(r *http.Request) *slog.Logger {
+	lg, ok := r.Context().Value(ctxKeyLogger).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+	return lg
+}
+
+// setupLogging makes our structured logger the default, including for anything still using the standard `log` package.
+func setupLogging(w io.Writer, level, format string) {
+	slog.SetDefault(newLogger(w, level, format))
+}
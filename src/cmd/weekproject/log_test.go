@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     string
+		args    []interface{}
+		hidden  []string
+		visible []string
+	}{
+		{"token", "posting", []interface{}{"token", "abc123"}, []string{"abc123"}, []string{`"token":"[redacted]"`}},
+		{"access token", "posting", []interface{}{"accessToken", "abc123"}, []string{"abc123"}, nil},
+		{"secret", "signing", []interface{}{"NudgeSecret", "s3cret"}, []string{"s3cret"}, nil},
+		{"password", "smtp", []interface{}{"password", "hunter2"}, []string{"hunter2"}, nil},
+		{"email key", "mailing", []interface{}{"email", "not-an-address"}, []string{"not-an-address"}, nil},
+		{"cookie", "request", []interface{}{"Cookie", "session=xyz"}, []string{"session=xyz"}, nil},
+		{"authorization", "request", []interface{}{"Authorization", "Bearer xyz"}, []string{"Bearer xyz"}, nil},
+		{"email in a value", "mailing", []interface{}{"to", "chilts@example.com"}, []string{"chilts@example.com"}, []string{`"to":"[redacted]"`}},
+		{"email in the message", "sent to chilts@example.com", nil, []string{"chilts@example.com"}, []string{`"msg":"sent to [redacted]"`}},
+		{"email in an error", "mailing", []interface{}{"err", errors.New("550 no such user chilts@example.com")}, []string{"chilts@example.com"}, []string{"550 no such user [redacted]"}},
+		{"in a group", "posting", []interface{}{slog.Group("credential", "provider", "twitter", "secret", "s3cret")}, []string{"s3cret"}, []string{`"provider":"twitter"`}},
+		{"email in a group", "mailing", []interface{}{slog.Group("mail", "to", "chilts@example.com")}, []string{"chilts@example.com"}, nil},
+		{"nothing to hide", "posting", []interface{}{"userName", "chilts", "count", 3}, nil, []string{`"userName":"chilts"`, `"count":3`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			newLogger(buf, "debug", "json").Info(tt.msg, tt.args...)
+			out := buf.String()
+
+			for _, s := range tt.hidden {
+				if strings.Contains(out, s) {
+					t.Errorf("%q was logged: %s", s, out)
+				}
+			}
+			for _, s := range tt.visible {
+				if !strings.Contains(out, s) {
+					t.Errorf("%q wasn't logged: %s", s, out)
+				}
+			}
+		})
+	}
+}
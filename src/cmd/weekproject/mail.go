@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email. There's an SMTP implementation for production and a WriterMailer for development and tests.
+type Mailer interface {
+	Send(m Message) error
+}
+
+// headerSanitiser stops anyone getting extra headers into a message via a subject or address.
+var headerSanitiser = strings.NewReplacer("\r", "", "\n", "")
+
+// format renders the message, including headers, ready to be sent.
+func (m Message) format(from string, now time.Time) []byte {
+	msg := "From: " + headerSanitiser.Replace(from) + "\r\n" +
+		"To: " + headerSanitiser.Replace(m.To) + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", headerSanitiser.Replace(m.Subject)) + "\r\n" +
+		"Date: " + now.Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.Replace(m.Body, "\n", "\r\n", -1)
+	return []byte(msg)
+}
+
+// SMTPMailer sends email through an SMTP server.
+type SMTPMailer struct {
+	Addr string // e.g. "smtp.example.com:587"
+	From string // e.g. "The Week Project <noreply@weekproject.com>"
+	Auth smtp.Auth
+}
+
+func (s *SMTPMailer) Send(m Message) error {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return err
+	}
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return err
+	}
+
+	return smtp.SendMail(s.Addr, s.Auth, from.Address, []string{to.Address}, m.format(s.From, time.Now()))
+}
+
+// WriterMailer writes each message to W rather than sending it, such as to stdout in development or to a buffer in
+// tests.
+type WriterMailer struct {
+	From string
+
+	mu sync.Mutex
+	W  io.Writer
+}
+
+func (wm *WriterMailer) Send(m Message) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	_, err := fmt.Fprintf(wm.W, "%s\r\n.\r\n", m.format(wm.From, time.Now()))
+	return err
+}
+
+// newMailer creates the mailer from the env. MAILER is "smtp" (which uses SMTP_ADDR, SMTP_USER and SMTP_PASS),
+// "file" (which appends to MAIL_FILE) or, by default, "stdout". Every mailer sends from MAIL_FROM.
+func newMailer() (Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "The Week Project <noreply@weekproject.com>"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		addr := os.Getenv("SMTP_ADDR")
+		var auth smtp.Auth
+		if user := os.Getenv("SMTP_USER"); user != "" {
+			host := strings.Split(addr, ":")[0]
+			auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASS"), host)
+		}
+		return &SMTPMailer{Addr: addr, From: from, Auth: auth}, nil
+	case "file":
+		f, err := os.OpenFile(os.Getenv("MAIL_FILE"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &WriterMailer{From: from, W: f}, nil
+	case "", "stdout":
+		return &WriterMailer{From: from, W: os.Stdout}, nil
+	}
+
+	return nil, fmt.Errorf("unknown MAILER %q", os.Getenv("MAILER"))
+}
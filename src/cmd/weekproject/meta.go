@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// siteName and siteDescription are used for any page which doesn't say more about itself.
+const (
+	siteName        = "The Week Project"
+	siteDescription = "Learn something new in a week, posting updates on your progress as you go."
+)
+
+// how much of a project's content goes into it's description
+const metaDescriptionLen = 200
+
+// Meta is what a page tells search engines and social sites about itself, shown in the header as the description,
+// canonical URL, and Open Graph and Twitter Card tags. Pages put it in their data as `Meta`, and those which don't
+// get one made from their `Title` by pageMeta.
+type Meta struct {
+	Title       string
+	Description string
+	Url         string // the canonical URL, which must be absolute
+	Image       string // absolute, 1200x630
+	Type        string // for Open Graph, e.g. "website" or "article"
+	NoIndex     bool   // for pages which shouldn't be found, e.g. unlisted projects
+}
+
+// pageMeta returns the `Meta` field from a page's data, or a default made from it's `Title` field if it doesn't have
+// one. Data is usually an anonymous struct, so this has to look for the fields by name.
+func pageMeta(data interface{}) Meta {
+	m := Meta{Title: siteName, Description: siteDescription, Type: "website"}
+
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Struct {
+		return m
+	}
+
+	if f := v.FieldByName("Meta"); f.IsValid() {
+		if meta, ok := f.Interface().(*Meta); ok && meta != nil {
+			return *meta
+		}
+	}
+	if f := v.FieldByName("Title"); f.IsValid() && f.Kind() == reflect.String && f.String() != "" {
+		m.Title = f.String()
+	}
+
+	return m
+}
+
+// ProjectMeta is the meta for a project's public page. The canonical URL never has the share token, but the image
+// needs it to be seen at all.
+func ProjectMeta(p *Project, baseUrl string) *Meta {
+	share := ""
+	if p.Visibility == VisibilityUnlisted {
+		share = "?share=" + p.ShareToken
+	}
+
+	description := summarise(p.Content, metaDescriptionLen)
+	if description == "" {
+		description = "A week project by @" + p.UserName + " on " + siteName + "."
+	}
+
+	return &Meta{
+		Title:       p.Title,
+		Description: description,
+		Url:         baseUrl + escapePath(p.Url()),
+		Image:       baseUrl + escapePath(p.Url()+"preview.png") + share,
+		Type:        "article",
+		NoIndex:     !p.IsPublic(),
+	}
+}
+
+// escapePath escapes anything in `path` which can't be in a URL as it is, such as non-ASCII chars in a project's name.
+func escapePath(path string) string {
+	return (&url.URL{Path: path}).String()
+}
+
+// summarise squashes any whitespace in `s` and cuts it short at a word boundary if it's longer than `max` chars.
+func summarise(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+
+	cut := string([]rune(s)[:max-1])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPageMeta(t *testing.T) {
+	project := &Meta{Title: "Learn Go", Url: "https://example.com/u/chilts/p/learn-go/", Type: "article"}
+
+	tests := []struct {
+		name string
+		data interface{}
+		want Meta
+	}{
+		{"nil", nil, Meta{Title: siteName, Description: siteDescription, Type: "website"}},
+		{"not a struct", "Learn Go", Meta{Title: siteName, Description: siteDescription, Type: "website"}},
+		{"no title", struct{ Count int }{3}, Meta{Title: siteName, Description: siteDescription, Type: "website"}},
+		{"empty title", struct{ Title string }{""}, Meta{Title: siteName, Description: siteDescription, Type: "website"}},
+		{"title", struct{ Title string }{"Sign in"}, Meta{Title: "Sign in", Description: siteDescription, Type: "website"}},
+		{"title not a string", struct{ Title int }{3}, Meta{Title: siteName, Description: siteDescription, Type: "website"}},
+		{"pointer", &struct{ Title string }{"Sign in"}, Meta{Title: "Sign in", Description: siteDescription, Type: "website"}},
+		{"meta", struct {
+			Title string
+			Meta  *Meta
+		}{"Sign in", project}, *project},
+		{"nil meta", struct {
+			Title string
+			Meta  *Meta
+		}{"Sign in", nil}, Meta{Title: "Sign in", Description: siteDescription, Type: "website"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pageMeta(tt.data); got != tt.want {
+				t.Errorf("pageMeta() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectMeta(t *testing.T) {
+	db := testDB(t)
+	public := testProject(t, db, "chilts", "Learn Go", VisibilityPublic)
+	unlisted := testProject(t, db, "chilts", "Learn Rust", VisibilityUnlisted)
+	private := testProject(t, db, "chilts", "Learn Zig", VisibilityPrivate)
+
+	meta := ProjectMeta(public, "https://example.com")
+	if meta.NoIndex {
+		t.Error("public project has NoIndex")
+	}
+	if meta.Url != "https://example.com/u/chilts/p/learn-go/" || meta.Image != meta.Url+"preview.png" {
+		t.Errorf("meta has %s and %s", meta.Url, meta.Image)
+	}
+	if meta.Description != "A week project by @chilts on "+siteName+"." {
+		t.Errorf("Description = %q, want the default", meta.Description)
+	}
+
+	// the image needs the share token to be seen, but the canonical URL mustn't give it away
+	meta = ProjectMeta(unlisted, "https://example.com")
+	if !meta.NoIndex {
+		t.Error("unlisted project doesn't have NoIndex")
+	}
+	if strings.Contains(meta.Url, unlisted.ShareToken) {
+		t.Errorf("Url = %s, which has the share token", meta.Url)
+	}
+	if !strings.HasSuffix(meta.Image, "preview.png?share="+unlisted.ShareToken) {
+		t.Errorf("Image = %s, want it to have the share token", meta.Image)
+	}
+
+	if meta := ProjectMeta(private, "https://example.com"); !meta.NoIndex {
+		t.Error("private project doesn't have NoIndex")
+	}
+
+	// names which aren't ASCII are escaped
+	p := testProject(t, db, "chilts", "学习中文", VisibilityPublic)
+	want := "https://example.com/u/chilts/p/%E5%AD%A6%E4%B9%A0%E4%B8%AD%E6%96%87/"
+	if meta := ProjectMeta(p, "https://example.com"); meta.Url != want || meta.Image != want+"preview.png" {
+		t.Errorf("meta has %s and %s, want %s and it's preview.png", meta.Url, meta.Image, want)
+	}
+}
+
+func TestSummarise(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"", 10, ""},
+		{"  Learn\n\tGo  ", 10, "Learn Go"},
+		{"Learn Go", 8, "Learn Go"},
+		{"Learn Go in a week", 12, "Learn Go…"},
+		{"Learn Golang", 8, "Learn…"},
+		{"Supercalifragilistic", 8, "Superca…"},
+		{"学习中文 学习中文", 6, "学习中文…"},
+	}
+
+	for _, tt := range tests {
+		got := summarise(tt.s, tt.max)
+		if got != tt.want {
+			t.Errorf("summarise(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+		if n := utf8.RuneCountInString(got); n > tt.max {
+			t.Errorf("summarise(%q, %d) has %d chars", tt.s, tt.max, n)
+		}
+	}
+
+	long := strings.Repeat("learning go ", 50)
+	if n := utf8.RuneCountInString(summarise(long, metaDescriptionLen)); n > metaDescriptionLen {
+		t.Errorf("description has %d chars, want at most %d", n, metaDescriptionLen)
+	}
+}
+
+func TestPreviewPng(t *testing.T) {
+	for _, p := range []*Project{
+		{Title: "Learn Go", UserName: "chilts", Progress: 40},
+		{Title: strings.Repeat("A very long title ", 20), UserName: "chilts", Progress: 150},
+		{Title: "学习中文", UserName: "chilts", Progress: -5},
+	} {
+		b, err := PreviewPng(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := img.Bounds().Size(); size.X != 1200 || size.Y != 630 {
+			t.Errorf("preview for %q is %dx%d, want 1200x630", p.Title, size.X, size.Y)
+		}
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		lines int
+		want  []string
+	}{
+		{"Learn Go", 10, 3, []string{"Learn Go"}},
+		{"Learn Go in a week", 10, 3, []string{"Learn Go", "in a week"}},
+		{"Supercalifragilistic", 10, 3, []string{"Supercalif", "ragilistic"}},
+		{"one two three four five six", 5, 2, []string{"one", "tw..."}},
+	}
+
+	for _, tt := range tests {
+		got := wrapText(tt.s, tt.width, tt.lines)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("wrapText(%q, %d, %d) = %q, want %q", tt.s, tt.width, tt.lines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/pat"
+)
+
+// We don't pull in the full Prometheus client, since all we need is a few counters and histograms written out in the
+// text exposition format. See : https://prometheus.io/docs/instrumenting/exposition_formats/
+
+var defBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	httpRequests = newCounterVec(
+		"weekproject_http_requests_total",
+		"Count of HTTP requests by method, route pattern and status code.",
+		"method", "route", "code",
+	)
+	httpDuration = newHistogramVec(
+		"weekproject_http_request_duration_seconds",
+		"HTTP request latency by method and route pattern.",
+		"method", "route",
+	)
+	renderDuration = newHistogramVec(
+		"weekproject_template_render_duration_seconds",
+		"Time taken to execute each template.",
+		"template",
+	)
+)
+
+// labelKey joins label values together so they can be used as a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// writeLabels writes `{name="value",...}` for the given names and values, plus any extras (e.g. `le`).
+func writeLabels(w io.Writer, names, values []string, extra ...string) {
+	pairs := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "{%s}", strings.Join(pairs, ","))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// counterVec is a counter partitioned by a set of labels.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		keys:   make(map[string][]string),
+	}
+}
+
+// Inc adds one to the counter with these label values, which must be in the same order as the labels.
+func (c *counterVec) Inc(values ...string) {
+	key := labelKey(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[key]++
+	c.keys[key] = values
+}
+
+func (c *counterVec) Write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.keys) {
+		fmt.Fprintf(w, "%s", c.name)
+		writeLabels(w, c.labels, c.keys[key])
+		fmt.Fprintf(w, " %v\n", c.values[key])
+	}
+}
+
+// histogramVec is a histogram partitioned by a set of labels.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts map[string][]uint64
+	sums   map[string]float64
+	totals map[string]uint64
+	keys   map[string][]string
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: defBuckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]uint64),
+		keys:    make(map[string][]string),
+	}
+}
+
+// Observe records a value (e.g. seconds) against the histogram with these label values.
+func (h *histogramVec) Observe(v float64, values ...string) {
+	key := labelKey(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[key] = counts
+		h.keys[key] = values
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			counts[i]++
+		}
+	}
+	h.sums[key] += v
+	h.totals[key]++
+}
+
+// Since is a convenience for observing the number of seconds elapsed since `start`.
+func (h *histogramVec) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *histogramVec) Write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.keys) {
+		values := h.keys[key]
+		for i, le := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket", h.name)
+			writeLabels(w, h.labels, values, "le", fmt.Sprintf("%v", le))
+			fmt.Fprintf(w, " %d\n", h.counts[key][i])
+		}
+		fmt.Fprintf(w, "%s_bucket", h.name)
+		writeLabels(w, h.labels, values, "le", "+Inf")
+		fmt.Fprintf(w, " %d\n", h.totals[key])
+		fmt.Fprintf(w, "%s_sum", h.name)
+		writeLabels(w, h.labels, values)
+		fmt.Fprintf(w, " %v\n", h.sums[key])
+		fmt.Fprintf(w, "%s_count", h.name)
+		writeLabels(w, h.labels, values)
+		fmt.Fprintf(w, " %d\n", h.totals[key])
+	}
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeGauge writes a single unlabelled gauge.
+func writeGauge(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, v)
+}
+
+// writeCounter writes a single unlabelled counter.
+func writeCounter(w io.Writer, name, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %v\n", name, help, name, name, v)
+}
+
+// statusWriter captures the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// instrument wraps a handler so that each request is counted and timed against its route pattern, rather than the
+// path, so we don't end up with a metric per user or project.
+func instrument(method, pattern string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{w, http.StatusOK}
+
+		h(sw, r)
+
+		httpRequests.Inc(method, pattern, fmt.Sprintf("%d", sw.status))
+		httpDuration.Since(start, method, pattern)
+	}
+}
+
+// router is a `pat.Router` which instruments every handler added to it.
+type router struct {
+	*pat.Router
+}
+
+func newRouter() router {
+	return router{pat.New()}
+}
+
+func (r router) Get(pattern string, h http.HandlerFunc) *mux.Route {
+	return r.Router.Get(pattern, instrument("GET", pattern, h))
+}
+
+func (r router) Post(pattern string, h http.HandlerFunc) *mux.Route {
+	return r.Router.Post(pattern, instrument("POST", pattern, h))
+}
+
+// how long the counts are kept before walking the store again, since that reads every user and project
+const countsMaxAge = time.Minute
+
+// countsCache keeps the last counts from GetCounts, so scrapes don't each walk the whole store.
+type countsCache struct {
+	db     *bolt.DB
+	maxAge time.Duration
+
+	mu     sync.Mutex
+	counts Counts
+	at     time.Time
+}
+
+// Get returns the cached counts, refreshing them first if they're older than maxAge. Failures aren't cached, so the
+// next call tries again.
+func (c *countsCache) Get(now time.Time) (Counts, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.at.IsZero() && now.Sub(c.at) < c.maxAge {
+		return c.counts, nil
+	}
+
+	counts, err := GetCounts(c.db, now)
+	if err != nil {
+		return Counts{}, err
+	}
+	c.counts = counts
+	c.at = now
+	return counts, nil
+}
+
+// metricsHandler serves all metrics in the Prometheus text format, to anyone with `token` as a bearer token. With no
+// token, metrics aren't served at all.
+func metricsHandler(db *bolt.DB, token string) http.HandlerFunc {
+	cache := &countsCache{db: db, maxAge: countsMaxAge}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			renderError(w, r, errNotFound)
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// get the counts first, so a failure isn't served as a partial scrape
+		counts, err := cache.Get(time.Now().UTC())
+		if err != nil {
+			logFor(r).Error("getting counts", "err", err)
+			http.Error(w, "counts not available", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+		httpRequests.Write(w)
+		httpDuration.Write(w)
+		renderDuration.Write(w)
+
+		// Bolt
+		stats := db.Stats()
+		writeCounter(w, "weekproject_bolt_read_tx_total", "Total number of started read transactions.", float64(stats.TxN))
+		writeGauge(w, "weekproject_bolt_open_read_tx", "Number of currently open read transactions.", float64(stats.OpenTxN))
+		writeGauge(w, "weekproject_bolt_free_pages", "Number of free pages on the freelist.", float64(stats.FreePageN))
+		writeGauge(w, "weekproject_bolt_pending_pages", "Number of pending pages on the freelist.", float64(stats.PendingPageN))
+		writeGauge(w, "weekproject_bolt_free_alloc_bytes", "Bytes allocated in free pages.", float64(stats.FreeAlloc))
+		writeCounter(w, "weekproject_bolt_page_alloc_bytes_total", "Total bytes allocated for pages.", float64(stats.TxStats.PageAlloc))
+		writeCounter(w, "weekproject_bolt_writes_total", "Total number of writes performed.", float64(stats.TxStats.Write))
+		writeCounter(w, "weekproject_bolt_write_seconds_total", "Total time spent writing to disk.", stats.TxStats.WriteTime.Seconds())
+		writeCounter(w, "weekproject_bolt_spill_seconds_total", "Total time spent spilling nodes.", stats.TxStats.SpillTime.Seconds())
+		writeCounter(w, "weekproject_bolt_rebalance_seconds_total", "Total time spent rebalancing nodes.", stats.TxStats.RebalanceTime.Seconds())
+
+		// business, which may be up to countsMaxAge old
+		writeGauge(w, "weekproject_users", "Total number of users.", float64(counts.Users))
+		writeGauge(w, "weekproject_projects", "Total number of projects.", float64(counts.Projects))
+		writeGauge(w, "weekproject_projects_active_week", "Projects created or updated in the last 7 days.", float64(counts.ActiveProjects))
+		writeGauge(w, "weekproject_updates_day", "Updates posted in the last 24 hours.", float64(counts.UpdatesToday))
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := newCounterVec("test_requests_total", "Requests.", "route", "code")
+	c.Inc(`/u/{userName}/`, "200")
+	c.Inc(`/u/{userName}/`, "200")
+	c.Inc("a \"quoted\" \\ route\nwith a newline", "500")
+
+	buf := &bytes.Buffer{}
+	c.Write(buf)
+
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/u/{userName}/",code="200"} 2
+test_requests_total{route="a \"quoted\" \\ route\nwith a newline",code="500"} 1
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Latency.", "route")
+	h.buckets = []float64{0.1, 1}
+	h.Observe(0.05, "/")
+	h.Observe(0.5, "/")
+	h.Observe(2, "/")
+
+	buf := &bytes.Buffer{}
+	h.Write(buf)
+
+	want := `# HELP test_seconds Latency.
+# TYPE test_seconds histogram
+test_seconds_bucket{route="/",le="0.1"} 1
+test_seconds_bucket{route="/",le="1"} 2
+test_seconds_bucket{route="/",le="+Inf"} 3
+test_seconds_sum{route="/"} 2.55
+test_seconds_count{route="/"} 3
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	loadTemplates("../../../templates")
+	db := testDB(t)
+	testUser(t, db, "chilts")
+	testProject(t, db, "chilts", "Learn Go", VisibilityPublic)
+
+	tests := []struct {
+		name   string
+		token  string
+		auth   string
+		status int
+	}{
+		{"no METRICS_TOKEN", "", "Bearer ", http.StatusNotFound},
+		{"no bearer", "s3cret", "", http.StatusUnauthorized},
+		{"wrong bearer", "s3cret", "Bearer nope", http.StatusUnauthorized},
+		{"not a bearer", "s3cret", "s3cret", http.StatusUnauthorized},
+		{"right bearer", "s3cret", "Bearer s3cret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+
+			metricsHandler(db, tt.token)(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			if tt.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+			if tt.status != http.StatusOK {
+				if strings.Contains(w.Body.String(), "weekproject_") {
+					t.Errorf("metrics were served: %s", w.Body.String())
+				}
+				return
+			}
+
+			for _, line := range []string{
+				"# TYPE weekproject_http_requests_total counter",
+				"# TYPE weekproject_bolt_read_tx_total counter",
+				"weekproject_users 1\n",
+				"weekproject_projects 1\n",
+			} {
+				if !strings.Contains(w.Body.String(), line) {
+					t.Errorf("no %q in:\n%s", line, w.Body.String())
+				}
+			}
+		})
+	}
+}
+
+func TestCountsCache(t *testing.T) {
+	db := testDB(t)
+	testUser(t, db, "chilts")
+	cache := &countsCache{db: db, maxAge: time.Minute}
+	now := time.Now().UTC()
+
+	counts, err := cache.Get(now)
+	if err != nil || counts.Users != 1 {
+		t.Fatalf("counts = %+v, %v", counts, err)
+	}
+
+	testUser(t, db, "alice")
+	if counts, _ := cache.Get(now.Add(30 * time.Second)); counts.Users != 1 {
+		t.Errorf("users = %d within maxAge, want the cached 1", counts.Users)
+	}
+	if counts, _ := cache.Get(now.Add(time.Minute)); counts.Users != 2 {
+		t.Errorf("users = %d after maxAge, want 2", counts.Users)
+	}
+}
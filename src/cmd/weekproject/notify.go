@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// how often the notifier sends any due mail and webhooks, and checks projects for reminders
+var mailInterval = 30 * time.Second
+var checkInterval = time.Hour
+
+// how many times we try to send a message
+var maxMailAttempts = 6
+
+// Notifier decides who to email about what, puts those messages on the queue, and sends whatever is due. Messages
+// are queued rather than sent straight away so that a slow or broken mail server never holds up a request, and so
+// nothing is lost on a restart.
+type Notifier struct {
+	db       *bolt.DB
+	mailer   Mailer
+	baseUrl  string
+	client   *http.Client // for webhooks and nudges, which can't reach private addresses
+	channels map[string]NudgeChannel
+	posters  map[string]Poster // by provider, for cross-posting
+	wg       sync.WaitGroup    // for cross-posts still going
+}
+
+func NewNotifier(db *bolt.DB, mailer Mailer, posters map[string]Poster, baseUrl string) *Notifier {
+	n := &Notifier{db: db, mailer: mailer, posters: posters, baseUrl: baseUrl}
+	n.client = newOutgoingClient(webhookTimeout)
+	n.channels = map[string]NudgeChannel{
+		NudgeEmail:   &emailChannel{n},
+		NudgeWebhook: &webhookChannel{n.client},
+	}
+	return n
+}
+
+// NewComment tells the project owner about a comment, unless they wrote it themselves.
+func (n *Notifier) NewComment(p Project, c Comment) error {
+	if c.Author == p.UserName {
+		return nil
+	}
+
+	body := fmt.Sprintf("%s commented on your project \"%s\":\n\n%s\n\n%s\n", c.Author, p.Title, c.Body, n.baseUrl+p.Url()+"#comments")
+	return n.notify(p.UserName, EventNewComment, "New comment on "+p.Title, body)
+}
+
+// NewFollower tells `followee` that `follower` is now following them.
+func (n *Notifier) NewFollower(follower, followee string) error {
+	body := fmt.Sprintf("%s is now following you.\n\n%s\n", follower, n.baseUrl+"/u/"+follower+"/")
+	return n.notify(followee, EventNewFollower, follower+" is following you", body)
+}
+
+// notify queues a message for this user if they want to hear about this event and we have somewhere to send it.
+func (n *Notifier) notify(userName, event, subject, body string) error {
+	user, err := GetUser(n.db, userName)
+	if err != nil {
+		return err
+	}
+	if user.Name == "" {
+		return nil
+	}
+
+	prefs, err := GetPrefs(n.db, userName)
+	if err != nil {
+		return err
+	}
+	if !prefs.Wants(event) {
+		return nil
+	}
+
+	to := prefs.Email
+	if to == "" {
+		to = user.Email
+	}
+	if to == "" {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	m := QueuedMail{
+		Id:          now.Format(idFormat) + "-" + randomHex(4),
+		Message:     Message{To: to, Subject: subject, Body: body + "\n--\nChange which emails you get at " + n.baseUrl + "/settings\n"},
+		Event:       event,
+		NextAttempt: now,
+		Inserted:    now,
+	}
+	return InsMail(n.db, m)
+}
+
+// CheckProjects looks at every active project and reminds the owner if it's week ends within the next day. Each
+// reminder is only sent once. Projects which have gone quiet are handled by NudgeInactive.
+func (n *Notifier) CheckProjects(now time.Time) error {
+	activity, bad, err := SelActivity(n.db, now.Add(-7*24*time.Hour))
+	if err != nil {
+		return err
+	}
+	logBadRecords(slog.Default(), bad)
+
+	for _, a := range activity {
+		p := a.Project
+		if !p.IsActive(now) {
+			continue
+		}
+
+		if p.Ends().Sub(now) < 24*time.Hour {
+			marked, err := MarkNotified(n.db, *p, EventWeekEnding, p.Ends().Format(format))
+			if err != nil {
+				return err
+			}
+			if marked {
+				body := fmt.Sprintf("The week for your project \"%s\" ends tomorrow, and it's at %d%%.\n\n%s\n", p.Title, p.Progress, n.baseUrl+p.Url())
+				err = n.notify(p.UserName, EventWeekEnding, p.Title+" ends tomorrow", body)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// SendDue sends any queued mail which is due. A message which fails is tried again later, backing off each time,
+// until it has failed maxMailAttempts times.
+func (n *Notifier) SendDue(now time.Time) error {
+	due, bad, err := SelDueMail(n.db, now, 100)
+	if err != nil {
+		return err
+	}
+	logBadRecords(slog.Default(), bad)
+
+	for _, m := range due {
+		errSend := n.mailer.Send(m.Message)
+		if errSend == nil {
+			slog.Info("sent mail", "id", m.Id, "event", m.Event)
+			err = DelMail(n.db, m.Id)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		m.Attempts++
+		m.LastError = errSend.Error()
+		if m.Attempts >= maxMailAttempts {
+			slog.Error("giving up on mail", "id", m.Id, "event", m.Event, "attempts", m.Attempts, "err", errSend)
+			err = FailMail(n.db, *m)
+		} else {
+			slog.Warn("sending mail", "id", m.Id, "event", m.Event, "attempts", m.Attempts, "err", errSend)
+			m.NextAttempt = now.Add(time.Minute << uint(m.Attempts))
+			err = UpdMail(n.db, *m)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run sends due mail and webhooks, and checks projects, periodically until `ctx` is done. It then waits for any
+// cross-posts which are still going.
+func (n *Notifier) Run(ctx context.Context) {
+	defer n.wg.Wait()
+
+	mailTicker := time.NewTicker(mailInterval)
+	defer mailTicker.Stop()
+	checkTicker := time.NewTicker(checkInterval)
+	defer checkTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-mailTicker.C:
+			if err := n.SendDue(time.Now().UTC()); err != nil {
+				slog.Error("sending due mail", "err", err)
+			}
+			if err := n.DeliverDue(time.Now().UTC()); err != nil {
+				slog.Error("delivering due webhooks", "err", err)
+			}
+		case <-checkTicker.C:
+			if err := n.CheckProjects(time.Now().UTC()); err != nil {
+				slog.Error("checking projects", "err", err)
+			}
+			if err := n.NudgeInactive(time.Now().UTC()); err != nil {
+				slog.Error("nudging inactive projects", "err", err)
+			}
+		}
+	}
+}
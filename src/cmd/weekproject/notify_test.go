@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/chilts/rod"
+)
+
+// failMailer fails to send anything.
+type failMailer struct{}
+
+func (failMailer) Send(m Message) error {
+	return errors.New("connection refused")
+}
+
+// testUser puts a user with an email address into the store.
+func testUser(t *testing.T, db *bolt.DB, name string) {
+	t.Helper()
+	if _, err := InsUser(db, User{Name: name, Title: name, Email: name + "@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// queued returns everything on the mail queue, whether it's due or not.
+func queued(t *testing.T, db *bolt.DB) []*QueuedMail {
+	t.Helper()
+	mail, _, err := SelDueMail(db, time.Now().UTC().Add(365*24*time.Hour), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mail
+}
+
+func TestNotifierQueueThenSend(t *testing.T) {
+	db := testDB(t)
+	testUser(t, db, "bob")
+
+	buf := &bytes.Buffer{}
+	n := NewNotifier(db, &WriterMailer{From: "test@example.com", W: buf}, nil, "https://example.com")
+
+	if err := n.NewFollower("alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("mail was sent before SendDue")
+	}
+	if got := len(queued(t, db)); got != 1 {
+		t.Fatalf("queued %d messages, want 1", got)
+	}
+
+	if err := n.SendDue(time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := buf.String()
+	for _, want := range []string{"To: bob@example.com\r\n", "Subject: alice is following you\r\n", "https://example.com/u/alice/"} {
+		if !strings.Contains(sent, want) {
+			t.Errorf("sent mail doesn't contain %q", want)
+		}
+	}
+	if got := len(queued(t, db)); got != 0 {
+		t.Errorf("%d messages still queued after sending", got)
+	}
+}
+
+func TestNotifierOptOut(t *testing.T) {
+	db := testDB(t)
+	testUser(t, db, "bob")
+
+	prefs := DefaultPrefs()
+	prefs.NewFollower = false
+	if err := PutPrefs(db, "bob", prefs); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNotifier(db, failMailer{}, nil, "https://example.com")
+	if err := n.NewFollower("alice", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(queued(t, db)); got != 0 {
+		t.Errorf("queued %d messages for someone who opted out", got)
+	}
+}
+
+func TestNotifierBackoff(t *testing.T) {
+	db := testDB(t)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	m := QueuedMail{Id: "1", Message: Message{To: "bob@example.com", Subject: "Hi"}, NextAttempt: now, Inserted: now}
+	if err := InsMail(db, m); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNotifier(db, failMailer{}, nil, "https://example.com")
+
+	// each failure waits twice as long as the last before trying again
+	for attempt := 1; attempt < maxMailAttempts; attempt++ {
+		if err := n.SendDue(now); err != nil {
+			t.Fatal(err)
+		}
+
+		mail := queued(t, db)
+		if len(mail) != 1 {
+			t.Fatalf("attempt %d: queued %d messages, want 1", attempt, len(mail))
+		}
+		wait := time.Minute << uint(attempt)
+		if mail[0].Attempts != attempt || !mail[0].NextAttempt.Equal(now.Add(wait)) {
+			t.Fatalf("attempt %d: got attempts %d, next %v, want next %v", attempt, mail[0].Attempts, mail[0].NextAttempt, now.Add(wait))
+		}
+		if mail[0].LastError != "connection refused" {
+			t.Errorf("attempt %d: last error = %q", attempt, mail[0].LastError)
+		}
+
+		// it isn't due again until the wait is up
+		due, _, err := SelDueMail(db, now.Add(wait-time.Second), 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 0 {
+			t.Fatalf("attempt %d: due again before the backoff", attempt)
+		}
+		now = now.Add(wait)
+	}
+
+	// the last failure gives up, moving it out of the queue
+	if err := n.SendDue(now); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(queued(t, db)); got != 0 {
+		t.Fatalf("%d messages still queued after giving up", got)
+	}
+	failed := QueuedMail{}
+	err := db.View(func(tx *bolt.Tx) error {
+		return rod.GetJson(tx, "mailfailed", "1", &failed)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.Attempts != maxMailAttempts {
+		t.Errorf("failed mail has %d attempts, want %d", failed.Attempts, maxMailAttempts)
+	}
+}
+
+func TestMailQueueSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	now := time.Now().UTC()
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InsMail(db, QueuedMail{Id: "1", Message: Message{To: "bob@example.com", Subject: "Hi"}, NextAttempt: now, Inserted: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	buf := &bytes.Buffer{}
+	n := NewNotifier(db, &WriterMailer{W: buf}, nil, "https://example.com")
+	if err := n.SendDue(now); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "Subject: Hi\r\n") {
+		t.Errorf("the queued mail wasn't sent after reopening")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// how long we wait for someone's webhook to respond
+var webhookTimeout = 10 * time.Second
+
+// Nudge is a reminder that a project has gone quiet. It's also the JSON body posted to a nudge webhook.
+type Nudge struct {
+	Event        string    `json:"event"` // always EventNoUpdate
+	UserName     string    `json:"userName"`
+	ProjectName  string    `json:"projectName"`
+	ProjectTitle string    `json:"projectTitle"`
+	Progress     int       `json:"progress"`
+	LastUpdate   time.Time `json:"lastUpdate"`
+	Url          string    `json:"url"` // where to post an update
+	Text         string    `json:"text"`
+}
+
+// NudgeChannel is somewhere a nudge can be sent, chosen by each user in their Prefs.
+type NudgeChannel interface {
+	Send(prefs Prefs, n Nudge) error
+}
+
+// emailChannel puts the nudge on the mail queue, like any other notification.
+type emailChannel struct {
+	notifier *Notifier
+}
+
+func (c *emailChannel) Send(prefs Prefs, n Nudge) error {
+	return c.notifier.notify(n.UserName, EventNoUpdate, "How's "+n.ProjectTitle+" going?", n.Text+"\n\n"+n.Url+"\n")
+}
+
+// webhookChannel posts the nudge as JSON to the user's webhook, signed the same as any other webhook but with the
+// secret from their Prefs. Anything other than a 2xx is a failure.
+type webhookChannel struct {
+	client *http.Client
+}
+
+func (c *webhookChannel) Send(prefs Prefs, n Nudge) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, prefs.NudgeWebhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "WeekProject-Webhook/1.0")
+	req.Header.Set("X-WeekProject-Event", n.Event)
+	req.Header.Set("X-WeekProject-Signature", signWebhook(prefs.NudgeSecret, body))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", res.Status)
+	}
+	return nil
+}
+
+// NudgeInactive looks for active projects which haven't had an update for as long as their owner has said is too
+// long, and nudges the owner through whichever channel they've chosen. Nobody is nudged during their quiet hours,
+// and each project is nudged at most once per day in the owner's own time zone.
+func (n *Notifier) NudgeInactive(now time.Time) error {
+	activity, bad, err := SelActivity(n.db, now.Add(-7*24*time.Hour))
+	if err != nil {
+		return err
+	}
+	logBadRecords(slog.Default(), bad)
+
+	for _, a := range activity {
+		p := a.Project
+		if !p.IsActive(now) {
+			continue
+		}
+
+		prefs, err := GetPrefs(n.db, p.UserName)
+		if err != nil {
+			return err
+		}
+		if !prefs.Wants(EventNoUpdate) || now.Sub(a.LastUpdate) < prefs.NudgeAfterPeriod() || prefs.InQuietHours(now) {
+			continue
+		}
+
+		channel, ok := n.channels[prefs.NudgeChannel]
+		if !ok {
+			channel = n.channels[NudgeEmail]
+		}
+
+		// only the first check on each of their days gets to send one
+		marked, err := MarkNotified(n.db, *p, EventNoUpdate, now.In(prefs.Location()).Format("2006-01-02"))
+		if err != nil {
+			return err
+		}
+		if !marked {
+			continue
+		}
+
+		hours := int(now.Sub(a.LastUpdate).Hours())
+		nudge := Nudge{
+			Event:        EventNoUpdate,
+			UserName:     p.UserName,
+			ProjectName:  p.Name,
+			ProjectTitle: p.Title,
+			Progress:     p.Progress,
+			LastUpdate:   a.LastUpdate,
+			Url:          n.baseUrl + "/p/" + p.Name + "/update",
+			Text:         fmt.Sprintf("You haven't posted an update to \"%s\" for %d hours. How's it going?", p.Title, hours),
+		}
+
+		// a failed nudge isn't retried until tomorrow, so one broken webhook can't hold up everyone else's
+		err = channel.Send(prefs, nudge)
+		if err != nil {
+			slog.Warn("sending nudge", "userName", p.UserName, "projectName", p.Name, "channel", prefs.NudgeChannel, "err", err)
+			continue
+		}
+		slog.Info("sent nudge", "userName", p.UserName, "projectName", p.Name, "channel", prefs.NudgeChannel)
+	}
+
+	return nil
+}
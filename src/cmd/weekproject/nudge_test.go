@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// nudgeReceiver is a webhook which records each nudge it's sent, along with the signature header.
+type nudgeReceiver struct {
+	nudges     []Nudge
+	signatures []string
+	bodies     [][]byte
+}
+
+func (nr *nudgeReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	n := Nudge{}
+	json.Unmarshal(body, &n)
+	nr.nudges = append(nr.nudges, n)
+	nr.signatures = append(nr.signatures, r.Header.Get("X-WeekProject-Signature"))
+	nr.bodies = append(nr.bodies, body)
+}
+
+func TestNudgeInactive(t *testing.T) {
+	start := time.Now().UTC()
+
+	tests := []struct {
+		name   string
+		after  time.Duration // since the project was started
+		quiet  bool          // whether it's their quiet hours
+		nudges int
+	}{
+		{"quiet for long enough", 72 * time.Hour, false, 1},
+		{"not quiet for long enough", 24 * time.Hour, false, 0},
+		{"in their quiet hours", 72 * time.Hour, true, 0},
+		{"week is over", 8 * 24 * time.Hour, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testDB(t)
+			testUser(t, db, "bob")
+			p := testProject(t, db, "bob", "Learn Go", VisibilityPublic)
+
+			receiver := &nudgeReceiver{}
+			srv := httptest.NewServer(receiver)
+			defer srv.Close()
+
+			now := start.Add(tt.after)
+			prefs := DefaultPrefs()
+			prefs.NudgeChannel = NudgeWebhook
+			prefs.NudgeWebhook = srv.URL
+			prefs.NudgeSecret = "sekrit"
+			prefs.QuietFrom, prefs.QuietTo = 0, 0
+			if tt.quiet {
+				prefs.QuietFrom, prefs.QuietTo = now.Hour(), (now.Hour()+1)%24
+			}
+			if err := PutPrefs(db, "bob", prefs); err != nil {
+				t.Fatal(err)
+			}
+
+			// the test server is on loopback, which the real client won't connect to
+			n := NewNotifier(db, failMailer{}, nil, "https://example.com")
+			n.channels[NudgeWebhook] = &webhookChannel{srv.Client()}
+
+			if err := n.NudgeInactive(now); err != nil {
+				t.Fatal(err)
+			}
+			// a second check on the same day doesn't nudge them again
+			if err := n.NudgeInactive(now.Add(time.Minute)); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(receiver.nudges) != tt.nudges {
+				t.Fatalf("sent %d nudges, want %d", len(receiver.nudges), tt.nudges)
+			}
+			if tt.nudges == 0 {
+				return
+			}
+
+			got := receiver.nudges[0]
+			if got.Event != EventNoUpdate || got.UserName != "bob" || got.ProjectName != p.Name {
+				t.Errorf("nudge = %+v", got)
+			}
+			if got.Url != "https://example.com/p/"+p.Name+"/update" {
+				t.Errorf("url = %q", got.Url)
+			}
+			if want := signWebhook("sekrit", receiver.bodies[0]); receiver.signatures[0] != want {
+				t.Errorf("signature = %q, want %q", receiver.signatures[0], want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Webhooks and nudges are posted to URLs our users give us, so they mustn't be able to point us at our own network,
+// such as a service on localhost or the cloud metadata at 169.254.169.254. URLs are checked when they're saved, and
+// the address is checked again each time we connect, since DNS can change in between.
+
+var errPrivateAddress = errors.New("outgoing requests can't go to a private address")
+
+// blockedNets are the ranges outgoing requests can't go to, on top of those the net.IP methods know about.
+var blockedNets = parseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved, including broadcast
+	"64:ff9b::/96",  // NAT64, which can embed any of the above
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// privateAddress says whether `ip` is one outgoing requests can't go to, i.e. anything other than a public unicast
+// address.
+func privateAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupIP is net.LookupIP, which the tests can replace so they don't need DNS.
+var lookupIP = net.LookupIP
+
+// checkOutgoingUrl returns a message for the user if `raw` isn't somewhere we'll post to, or an empty string if it's
+// fine. It must be http or https, and it's host mustn't be or resolve to a private address. A host which doesn't
+// resolve yet is allowed, since it's checked again when we connect.
+func checkOutgoingUrl(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "URL should be an http or https URL"
+	}
+	if len(raw) > 1000 {
+		return "URL should be less than 1,000 chars"
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if privateAddress(ip) {
+			return "URL can't be a private or local address"
+		}
+		return ""
+	}
+
+	ips, err := lookupIP(host)
+	if err != nil {
+		return ""
+	}
+	for _, ip := range ips {
+		if privateAddress(ip) {
+			return "URL can't be a private or local address"
+		}
+	}
+	return ""
+}
+
+// outgoingControl is a net.Dialer.Control which refuses to connect to a private address, whatever the URL said.
+func outgoingControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || privateAddress(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// newOutgoingClient is the client for posting to our users' URLs. It doesn't use a proxy, since then it'd be the
+// proxy's address being checked rather than the URL's.
+func newOutgoingClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: outgoingControl}
+	transport := &http.Transport{
+		Proxy:               nil,
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: timeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckOutgoingUrl(t *testing.T) {
+	// pretend DNS, so the test doesn't need any
+	hosts := map[string][]net.IP{
+		"hooks.example.com": {net.ParseIP("93.184.216.34")},
+		"internal.example":  {net.ParseIP("93.184.216.34"), net.ParseIP("10.0.0.5")},
+		"localhost":         {net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	}
+	defer func(orig func(string) ([]net.IP, error)) { lookupIP = orig }(lookupIP)
+	lookupIP = func(host string) ([]net.IP, error) {
+		if ips, ok := hosts[host]; ok {
+			return ips, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://hooks.example.com/abc", true},
+		{"http://93.184.216.34:8080/abc", true},
+		{"https://not-yet.example.com/abc", true},
+		{"ftp://hooks.example.com/abc", false},
+		{"https:///abc", false},
+		{"hooks.example.com/abc", false},
+		{"http://localhost:8080/", false},
+		{"http://internal.example/", false},
+		{"http://127.0.0.1/", false},
+		{"http://10.1.2.3/", false},
+		{"http://172.16.0.1/", false},
+		{"http://192.168.1.1/", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://100.64.0.1/", false},
+		{"http://0.0.0.0/", false},
+		{"http://[::1]/", false},
+		{"http://[fe80::1]/", false},
+		{"http://[fd00::1]/", false},
+		{"http://[::ffff:127.0.0.1]/", false},
+		{"http://[64:ff9b::a9fe:a9fe]/", false},
+	}
+
+	for _, tt := range tests {
+		msg := checkOutgoingUrl(tt.url)
+		if (msg == "") != tt.ok {
+			t.Errorf("checkOutgoingUrl(%q) = %q, want ok %v", tt.url, msg, tt.ok)
+		}
+	}
+}
+
+func TestOutgoingClientRefusesPrivateAddresses(t *testing.T) {
+	hits := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer srv.Close()
+
+	// the test server is on loopback, which the URL checks would have stopped, as if DNS had changed since then
+	_, err := newOutgoingClient(time.Second).Get(srv.URL)
+	if !errors.Is(err, errPrivateAddress) {
+		t.Errorf("err = %v, want %v", err, errPrivateAddress)
+	}
+	if hits != 0 {
+		t.Errorf("the server was reached %d times", hits)
+	}
+}
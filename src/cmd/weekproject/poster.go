@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mrjones/oauth"
+)
+
+// Poster posts a status to a social account, using that account's credential. There's one per provider, plus a
+// FakePoster for development and tests.
+type Poster interface {
+	// Post returns the provider's id for the new post.
+	Post(c Credential, text string) (string, error)
+	// MaxLen is how many chars a post can be, along with how many any link counts for (e.g. Twitter counts every link as
+	// 23 chars, however long it really is).
+	MaxLen() (text int, link int)
+}
+
+const twitterStatusUpdate = "https://api.twitter.com/1.1/statuses/update.json"
+
+// TwitterPoster posts a tweet, signed with our consumer key and the user's access token from when they signed in.
+type TwitterPoster struct {
+	consumer *oauth.Consumer
+}
+
+func NewTwitterPoster(consumerKey, consumerSecret string) *TwitterPoster {
+	return &TwitterPoster{oauth.NewConsumer(consumerKey, consumerSecret, oauth.ServiceProvider{})}
+}
+
+func (tp *TwitterPoster) Post(c Credential, text string) (string, error) {
+	client, err := tp.consumer.MakeHttpClient(&oauth.AccessToken{Token: c.Token, Secret: c.Secret})
+	if err != nil {
+		return "", err
+	}
+	client.Timeout = webhookTimeout
+
+	res, err := client.PostForm(twitterStatusUpdate, url.Values{"status": {text}})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("twitter responded with %s", res.Status)
+	}
+
+	tweet := struct {
+		IdStr string `json:"id_str"`
+	}{}
+	err = json.NewDecoder(res.Body).Decode(&tweet)
+	return tweet.IdStr, err
+}
+
+func (tp *TwitterPoster) MaxLen() (int, int) {
+	return 280, 23
+}
+
+// FakePost is something "posted" by the FakePoster.
+type FakePost struct {
+	Credential Credential
+	Text       string
+}
+
+// FakePoster doesn't post anywhere, it just remembers what it was asked to post. Set Err to make every post fail.
+type FakePoster struct {
+	mu    sync.Mutex
+	Posts []FakePost
+	Err   error
+}
+
+func (fp *FakePoster) Post(c Credential, text string) (string, error) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	if fp.Err != nil {
+		return "", fp.Err
+	}
+	fp.Posts = append(fp.Posts, FakePost{c, text})
+	slog.Info("fake post", "provider", c.Provider, "text", text)
+	return fmt.Sprintf("fake-%d", len(fp.Posts)), nil
+}
+
+func (fp *FakePoster) MaxLen() (int, int) {
+	return 280, 23
+}
+
+// composePost makes the text of a cross-post: the project title and the update's status, truncated if need be so
+// that there's always room for the link back to the project.
+func composePost(p *Project, u *Update, link string, maxLen, linkLen int) string {
+	text := p.Title + ": " + strings.Join(strings.Fields(u.Status), " ")
+
+	room := maxLen - linkLen - 1 // the space before the link
+	if utf8.RuneCountInString(text) > room {
+		runes := []rune(text)
+		text = strings.TrimSpace(string(runes[:room-1])) + "…"
+	}
+
+	return text + " " + link
+}
+
+// CrossPost posts the update to the owner's social accounts, if the project has cross-posting turned on, and records
+// what was posted (or why it wasn't) in the project's audit. Only the owner's own updates are posted, since it's their
+// account. It runs in the background, and Run waits for any still going when it stops.
+func (n *Notifier) CrossPost(p Project, u Update) {
+	if !p.CrossPost || u.Author != p.UserName || !p.IsPublic() {
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+
+		for provider, poster := range n.posters {
+			c, err := GetCredential(n.db, p.UserName, provider)
+			if err != nil {
+				slog.Error("getting credential", "userName", p.UserName, "provider", provider, "err", err)
+				continue
+			}
+			if c.Token == "" {
+				continue
+			}
+
+			maxLen, linkLen := poster.MaxLen()
+			now := time.Now().UTC()
+			sp := SocialPost{
+				Id:       now.Format(idFormat),
+				UpdateId: u.Id,
+				UserName: p.UserName,
+				Provider: provider,
+				Text:     composePost(&p, &u, n.baseUrl+p.Url(), maxLen, linkLen),
+				Inserted: now,
+			}
+
+			sp.RemoteId, err = poster.Post(c, sp.Text)
+			if err != nil {
+				slog.Warn("cross-posting update", "userName", p.UserName, "projectName", p.Name, "provider", provider, "err", err)
+				sp.Error = err.Error()
+			}
+
+			err = InsSocialPost(n.db, p, sp)
+			if err != nil {
+				slog.Error("inserting social post", "userName", p.UserName, "projectName", p.Name, "err", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+	"github.com/chilts/rod"
+)
+
+func TestComposePost(t *testing.T) {
+	p := &Project{Title: "Learn Go"}
+	link := "https://example.com/u/chilts/p/learn-go/"
+
+	tests := []struct {
+		name   string
+		status string
+		want   string
+	}{
+		{"short", "Read the tour", "Learn Go: Read the tour " + link},
+		{"whitespace is squashed", "Read\n\tthe   tour ", "Learn Go: Read the tour " + link},
+		{"just fits", strings.Repeat("a", 280-23-1-len("Learn Go: ")), "Learn Go: " + strings.Repeat("a", 280-23-1-len("Learn Go: ")) + " " + link},
+		{"one over", strings.Repeat("a", 280-23-len("Learn Go: ")), "Learn Go: " + strings.Repeat("a", 280-23-2-len("Learn Go: ")) + "… " + link},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := composePost(p, &Update{Status: tt.status}, link, 280, 23)
+			if got != tt.want {
+				t.Errorf("composePost() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// however long the status, the link is kept whole and it fits once the link counts as 23 chars
+	long := strings.Repeat("héllo wörld ", 100)
+	got := composePost(p, &Update{Status: long}, link, 280, 23)
+	if !strings.HasSuffix(got, "… "+link) {
+		t.Errorf("long post doesn't end with the link: %q", got)
+	}
+	if n := utf8.RuneCountInString(strings.TrimSuffix(got, link)) + 23; n > 280 {
+		t.Errorf("long post counts as %d chars", n)
+	}
+}
+
+// testCrossPoster sets up a public project with cross-posting on, owned by bob who has a credential.
+func testCrossPoster(t *testing.T, poster *FakePoster) (*bolt.DB, *Notifier, *Project) {
+	t.Helper()
+
+	t.Cleanup(func() { setCredentialKey("") })
+	setCredentialKey("test")
+
+	db := testDB(t)
+	p := testProject(t, db, "bob", "Learn Go", VisibilityPublic)
+	p.CrossPost = true
+	if err := InsProject(db, *p); err != nil {
+		t.Fatal(err)
+	}
+	if err := PutCredential(db, "bob", Credential{Provider: "twitter", Token: "token", Secret: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNotifier(db, failMailer{}, map[string]Poster{"twitter": poster}, "https://example.com")
+	return db, n, p
+}
+
+func TestCrossPost(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		author string
+		audit  bool
+	}{
+		{"posted", nil, "bob", true},
+		{"failed", errors.New("twitter responded with 503 Service Unavailable"), "bob", true},
+		{"not the owner", nil, "alice", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			poster := &FakePoster{Err: tt.err}
+			db, n, p := testCrossPoster(t, poster)
+
+			n.CrossPost(*p, Update{Id: "u1", Author: tt.author, Status: "Read the tour"})
+			n.wg.Wait()
+
+			posts, _, err := SelSocialPosts(db, "bob", p.Name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.audit {
+				if len(posts) != 0 || len(poster.Posts) != 0 {
+					t.Errorf("posted %d times and audited %d", len(poster.Posts), len(posts))
+				}
+				return
+			}
+			if len(posts) != 1 {
+				t.Fatalf("audited %d posts, want 1", len(posts))
+			}
+
+			sp := posts[0]
+			if sp.UpdateId != "u1" || sp.Provider != "twitter" || sp.Text != "Learn Go: Read the tour https://example.com/u/bob/p/learn-go/" {
+				t.Errorf("audit = %+v", sp)
+			}
+			if tt.err == nil {
+				if sp.RemoteId != "fake-1" || sp.Error != "" {
+					t.Errorf("audit = %+v, want it posted", sp)
+				}
+				if len(poster.Posts) != 1 || poster.Posts[0].Credential.Token != "token" {
+					t.Errorf("poster got %+v", poster.Posts)
+				}
+			} else if sp.RemoteId != "" || sp.Error != tt.err.Error() {
+				t.Errorf("audit = %+v, want the error", sp)
+			}
+		})
+	}
+}
+
+func TestCredentialEncrypted(t *testing.T) {
+	t.Cleanup(func() { setCredentialKey("") })
+	db := testDB(t)
+	c := Credential{Provider: "twitter", Token: "the-token", Secret: "the-secret"}
+
+	setCredentialKey("")
+	if err := PutCredential(db, "bob", c); err != errNoCredentialKey {
+		t.Fatalf("err = %v, want %v", err, errNoCredentialKey)
+	}
+
+	setCredentialKey("test")
+	if err := PutCredential(db, "bob", c); err != nil {
+		t.Fatal(err)
+	}
+
+	// it isn't in the store as it is
+	var raw []byte
+	db.View(func(tx *bolt.Tx) error {
+		v, err := rod.Get(tx, "user.bob.credential", "twitter")
+		raw = append(raw, v...)
+		return err
+	})
+	if len(raw) == 0 || strings.Contains(string(raw), "the-token") || strings.Contains(string(raw), "the-secret") {
+		t.Errorf("credential is kept as %s", raw)
+	}
+
+	got, err := GetCredential(db, "bob", "twitter")
+	if err != nil || got.Token != c.Token || got.Secret != c.Secret {
+		t.Errorf("GetCredential() = %+v, %v", got, err)
+	}
+
+	// it can't be moved to another user, or read with another key
+	sc := sealedCredential{}
+	db.View(func(tx *bolt.Tx) error {
+		return rod.GetJson(tx, "user.bob.credential", "twitter", &sc)
+	})
+	if _, err := openCredential("alice", sc); err == nil {
+		t.Errorf("opened bob's credential as alice")
+	}
+	setCredentialKey("another")
+	if _, err := GetCredential(db, "bob", "twitter"); err == nil {
+		t.Errorf("opened the credential with another key")
+	}
+	setCredentialKey("test")
+
+	if err := DelCredentials(db, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = GetCredential(db, "bob", "twitter")
+	if err != nil || got.Token != "" {
+		t.Errorf("GetCredential() after deleting = %+v, %v", got, err)
+	}
+}
+
+func TestPurgePlainCredentials(t *testing.T) {
+	t.Cleanup(func() { setCredentialKey("") })
+	setCredentialKey("test")
+	db := testDB(t)
+
+	// alice's is from before they were encrypted
+	err := db.Update(func(tx *bolt.Tx) error {
+		return rod.PutJson(tx, "user.alice.credential", "twitter", Credential{Provider: "twitter", Token: "plain"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PutCredential(db, "bob", Credential{Provider: "twitter", Token: "sealed"}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := PurgePlainCredentials(db)
+	if err != nil || n != 1 {
+		t.Fatalf("PurgePlainCredentials() = %d, %v, want 1", n, err)
+	}
+
+	if c, _ := GetCredential(db, "alice", "twitter"); c.Token != "" {
+		t.Errorf("alice's plain credential is still there")
+	}
+	if c, _ := GetCredential(db, "bob", "twitter"); c.Token != "sealed" {
+		t.Errorf("bob's sealed credential was purged")
+	}
+}
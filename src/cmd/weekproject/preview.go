@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"strconv"
+	"strings"
+)
+
+// The size of a social preview image, which is what Open Graph and Twitter Cards expect for a large image.
+const (
+	previewWidth  = 1200
+	previewHeight = 630
+	previewMargin = 60
+)
+
+// glyphs is a 5x7 pixel font for printable ASCII, from ' ' to '~'. Each row is 5 bits, with the leftmost pixel in the
+// highest bit. The standard library can't draw text, so we scale these up instead.
+var glyphs = [95][7]uint8{
+	{0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b00000}, // ' '
+	{0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00000, 0b00100}, // !
+	{0b01010, 0b01010, 0b01010, 0b00000, 0b00000, 0b00000, 0b00000}, // "
+	{0b01010, 0b01010, 0b11111, 0b01010, 0b11111, 0b01010, 0b01010}, // #
+	{0b00100, 0b01111, 0b10100, 0b01110, 0b00101, 0b11110, 0b00100}, // $
+	{0b11000, 0b11001, 0b00010, 0b00100, 0b01000, 0b10011, 0b00011}, // %
+	{0b01100, 0b10010, 0b10100, 0b01000, 0b10101, 0b10010, 0b01101}, // &
+	{0b00100, 0b00100, 0b01000, 0b00000, 0b00000, 0b00000, 0b00000}, // '
+	{0b00010, 0b00100, 0b01000, 0b01000, 0b01000, 0b00100, 0b00010}, // (
+	{0b01000, 0b00100, 0b00010, 0b00010, 0b00010, 0b00100, 0b01000}, // )
+	{0b00000, 0b00100, 0b10101, 0b01110, 0b10101, 0b00100, 0b00000}, // *
+	{0b00000, 0b00100, 0b00100, 0b11111, 0b00100, 0b00100, 0b00000}, // +
+	{0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b00100, 0b01000}, // ,
+	{0b00000, 0b00000, 0b00000, 0b11111, 0b00000, 0b00000, 0b00000}, // -
+	{0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b01100}, // .
+	{0b00000, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b00000}, // /
+	{0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110}, // 0
+	{0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110}, // 1
+	{0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111}, // 2
+	{0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110}, // 3
+	{0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010}, // 4
+	{0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110}, // 5
+	{0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110}, // 6
+	{0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000}, // 7
+	{0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110}, // 8
+	{0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100}, // 9
+	{0b00000, 0b01100, 0b01100, 0b00000, 0b01100, 0b01100, 0b00000}, // :
+	{0b00000, 0b01100, 0b01100, 0b00000, 0b01100, 0b00100, 0b01000}, // ;
+	{0b00010, 0b00100, 0b01000, 0b10000, 0b01000, 0b00100, 0b00010}, // <
+	{0b00000, 0b00000, 0b11111, 0b00000, 0b11111, 0b00000, 0b00000}, // =
+	{0b01000, 0b00100, 0b00010, 0b00001, 0b00010, 0b00100, 0b01000}, // >
+	{0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b00000, 0b00100}, // ?
+	{0b01110, 0b10001, 0b00001, 0b01101, 0b10101, 0b10101, 0b01110}, // @
+	{0b01110, 0b10001, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001}, // A
+	{0b11110, 0b10001, 0b10001, 0b11110, 0b10001, 0b10001, 0b11110}, // B
+	{0b01110, 0b10001, 0b10000, 0b10000, 0b10000, 0b10001, 0b01110}, // C
+	{0b11100, 0b10010, 0b10001, 0b10001, 0b10001, 0b10010, 0b11100}, // D
+	{0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b11111}, // E
+	{0b11111, 0b10000, 0b10000, 0b11110, 0b10000, 0b10000, 0b10000}, // F
+	{0b01110, 0b10001, 0b10000, 0b10111, 0b10001, 0b10001, 0b01111}, // G
+	{0b10001, 0b10001, 0b10001, 0b11111, 0b10001, 0b10001, 0b10001}, // H
+	{0b01110, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110}, // I
+	{0b00111, 0b00010, 0b00010, 0b00010, 0b00010, 0b10010, 0b01100}, // J
+	{0b10001, 0b10010, 0b10100, 0b11000, 0b10100, 0b10010, 0b10001}, // K
+	{0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b10000, 0b11111}, // L
+	{0b10001, 0b11011, 0b10101, 0b10101, 0b10001, 0b10001, 0b10001}, // M
+	{0b10001, 0b10001, 0b11001, 0b10101, 0b10011, 0b10001, 0b10001}, // N
+	{0b01110, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110}, // O
+	{0b11110, 0b10001, 0b10001, 0b11110, 0b10000, 0b10000, 0b10000}, // P
+	{0b01110, 0b10001, 0b10001, 0b10001, 0b10101, 0b10010, 0b01101}, // Q
+	{0b11110, 0b10001, 0b10001, 0b11110, 0b10100, 0b10010, 0b10001}, // R
+	{0b01111, 0b10000, 0b10000, 0b01110, 0b00001, 0b00001, 0b11110}, // S
+	{0b11111, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100}, // T
+	{0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01110}, // U
+	{0b10001, 0b10001, 0b10001, 0b10001, 0b10001, 0b01010, 0b00100}, // V
+	{0b10001, 0b10001, 0b10001, 0b10101, 0b10101, 0b10101, 0b01010}, // W
+	{0b10001, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001, 0b10001}, // X
+	{0b10001, 0b10001, 0b10001, 0b01010, 0b00100, 0b00100, 0b00100}, // Y
+	{0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b10000, 0b11111}, // Z
+	{0b01110, 0b01000, 0b01000, 0b01000, 0b01000, 0b01000, 0b01110}, // [
+	{0b00000, 0b10000, 0b01000, 0b00100, 0b00010, 0b00001, 0b00000}, // \
+	{0b01110, 0b00010, 0b00010, 0b00010, 0b00010, 0b00010, 0b01110}, // ]
+	{0b00100, 0b01010, 0b10001, 0b00000, 0b00000, 0b00000, 0b00000}, // ^
+	{0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b00000, 0b11111}, // _
+	{0b01000, 0b00100, 0b00010, 0b00000, 0b00000, 0b00000, 0b00000}, // `
+	{0b00000, 0b00000, 0b01110, 0b00001, 0b01111, 0b10001, 0b01111}, // a
+	{0b10000, 0b10000, 0b10110, 0b11001, 0b10001, 0b10001, 0b11110}, // b
+	{0b00000, 0b00000, 0b01110, 0b10000, 0b10000, 0b10001, 0b01110}, // c
+	{0b00001, 0b00001, 0b01101, 0b10011, 0b10001, 0b10001, 0b01111}, // d
+	{0b00000, 0b00000, 0b01110, 0b10001, 0b11111, 0b10000, 0b01110}, // e
+	{0b00110, 0b01001, 0b01000, 0b11100, 0b01000, 0b01000, 0b01000}, // f
+	{0b00000, 0b01111, 0b10001, 0b10001, 0b01111, 0b00001, 0b01110}, // g
+	{0b10000, 0b10000, 0b10110, 0b11001, 0b10001, 0b10001, 0b10001}, // h
+	{0b00100, 0b00000, 0b01100, 0b00100, 0b00100, 0b00100, 0b01110}, // i
+	{0b00010, 0b00000, 0b00110, 0b00010, 0b00010, 0b10010, 0b01100}, // j
+	{0b10000, 0b10000, 0b10010, 0b10100, 0b11000, 0b10100, 0b10010}, // k
+	{0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110}, // l
+	{0b00000, 0b00000, 0b11010, 0b10101, 0b10101, 0b10001, 0b10001}, // m
+	{0b00000, 0b00000, 0b10110, 0b11001, 0b10001, 0b10001, 0b10001}, // n
+	{0b00000, 0b00000, 0b01110, 0b10001, 0b10001, 0b10001, 0b01110}, // o
+	{0b00000, 0b00000, 0b11110, 0b10001, 0b11110, 0b10000, 0b10000}, // p
+	{0b00000, 0b00000, 0b01101, 0b10011, 0b01111, 0b00001, 0b00001}, // q
+	{0b00000, 0b00000, 0b10110, 0b11001, 0b10000, 0b10000, 0b10000}, // r
+	{0b00000, 0b00000, 0b01110, 0b10000, 0b01110, 0b00001, 0b11110}, // s
+	{0b01000, 0b01000, 0b11100, 0b01000, 0b01000, 0b01001, 0b00110}, // t
+	{0b00000, 0b00000, 0b10001, 0b10001, 0b10001, 0b10011, 0b01101}, // u
+	{0b00000, 0b00000, 0b10001, 0b10001, 0b10001, 0b01010, 0b00100}, // v
+	{0b00000, 0b00000, 0b10001, 0b10001, 0b10101, 0b10101, 0b01010}, // w
+	{0b00000, 0b00000, 0b10001, 0b01010, 0b00100, 0b01010, 0b10001}, // x
+	{0b00000, 0b00000, 0b10001, 0b10001, 0b01111, 0b00001, 0b01110}, // y
+	{0b00000, 0b00000, 0b11111, 0b00010, 0b00100, 0b01000, 0b11111}, // z
+	{0b00010, 0b00100, 0b00100, 0b01000, 0b00100, 0b00100, 0b00010}, // {
+	{0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100, 0b00100}, // |
+	{0b01000, 0b00100, 0b00100, 0b00010, 0b00100, 0b00100, 0b01000}, // }
+	{0b00000, 0b00000, 0b01000, 0b10101, 0b00010, 0b00000, 0b00000}, // ~
+}
+
+// glyphFor returns the glyph for `r`, which is a "?" for anything the font doesn't have.
+func glyphFor(r rune) [7]uint8 {
+	if r < ' ' || r > '~' {
+		r = '?'
+	}
+	return glyphs[r-' ']
+}
+
+// drawText draws `s` with it's top left at (x, y), with each pixel of the font `scale` pixels square. Glyphs are 5
+// wide with a gap of 1, so each char takes up 6*scale.
+func drawText(img draw.Image, x, y, scale int, s string, c color.Color) {
+	src := image.NewUniform(c)
+	for _, r := range s {
+		g := glyphFor(r)
+		for row := 0; row < 7; row++ {
+			for col := 0; col < 5; col++ {
+				if g[row]&(1<<uint(4-col)) == 0 {
+					continue
+				}
+				px := image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale)
+				draw.Draw(img, px, src, image.Point{}, draw.Src)
+			}
+		}
+		x += 6 * scale
+	}
+}
+
+// wrapText splits `s` into lines of at most `width` chars, breaking between words where it can, and cuts it off
+// with "..." if it needs more than `lines` lines.
+func wrapText(s string, width, lines int) []string {
+	wrapped := make([]string, 0, lines)
+	line := ""
+	for _, word := range strings.Fields(s) {
+		for len([]rune(word)) > width {
+			// a word which won't fit on any line is broken up
+			if line != "" {
+				wrapped = append(wrapped, line)
+				line = ""
+			}
+			wrapped = append(wrapped, string([]rune(word)[:width]))
+			word = string([]rune(word)[width:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case len([]rune(line))+1+len([]rune(word)) <= width:
+			line += " " + word
+		default:
+			wrapped = append(wrapped, line)
+			line = word
+		}
+	}
+	if line != "" {
+		wrapped = append(wrapped, line)
+	}
+
+	if len(wrapped) > lines {
+		wrapped = wrapped[:lines]
+		last := []rune(wrapped[lines-1])
+		if len(last) > width-3 {
+			last = last[:width-3]
+		}
+		wrapped[lines-1] = strings.TrimSpace(string(last)) + "..."
+	}
+	return wrapped
+}
+
+// hexColour parses a CSS colour such as "#4c1" or "#a4a61d", as used by progressColour.
+func hexColour(s string) color.RGBA {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	n, _ := strconv.ParseUint(s, 16, 32)
+	return color.RGBA{uint8(n >> 16), uint8(n >> 8), uint8(n), 0xff}
+}
+
+// PreviewPng draws the image shown when a project is shared, with it's title, owner and a bar showing it's progress.
+func PreviewPng(p *Project) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, previewWidth, previewHeight))
+
+	background := color.RGBA{0xf7, 0xf7, 0xf7, 0xff}
+	dark := color.RGBA{0x33, 0x33, 0x33, 0xff}
+	grey := color.RGBA{0x88, 0x88, 0x88, 0xff}
+	progress := hexColour(progressColour(p.Progress))
+
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	// a band across the top in the progress colour
+	draw.Draw(img, image.Rect(0, 0, previewWidth, 16), image.NewUniform(progress), image.Point{}, draw.Src)
+
+	// the title, as big as we can go while still fitting 3 lines
+	const titleScale = 9
+	width := (previewWidth - 2*previewMargin) / (6 * titleScale)
+	y := previewMargin + 16
+	for _, line := range wrapText(p.Title, width, 3) {
+		drawText(img, previewMargin, y, titleScale, line, dark)
+		y += 10 * titleScale
+	}
+
+	drawText(img, previewMargin, y+10, 5, "by @"+p.UserName, grey)
+
+	// the progress bar along the bottom, with the percentage above it
+	barTop := previewHeight - previewMargin - 48
+	drawText(img, previewMargin, barTop-70, 7, fmt.Sprintf("%d%% complete", p.Progress), dark)
+	bar := image.Rect(previewMargin, barTop, previewWidth-previewMargin, barTop+48)
+	draw.Draw(img, bar, image.NewUniform(color.RGBA{0xdd, 0xdd, 0xdd, 0xff}), image.Point{}, draw.Src)
+	done := bar
+	done.Max.X = bar.Min.X + bar.Dx()*clampProgress(p.Progress)/100
+	draw.Draw(img, done, image.NewUniform(progress), image.Point{}, draw.Src)
+
+	site := siteName
+	drawText(img, previewWidth-previewMargin-len(site)*6*3, previewHeight-previewMargin+16, 3, site, grey)
+
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, img)
+	return buf.Bytes(), err
+}
+
+// clampProgress keeps progress between 0 and 100, for drawing.
+func clampProgress(progress int) int {
+	if progress < 0 {
+		return 0
+	}
+	if progress > 100 {
+		return 100
+	}
+	return progress
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows each key (e.g. a user's name) up to `max` events in any `per` period. It's only kept in memory,
+// so it resets on restart, but that's fine for stopping someone flooding a project with comments.
+type rateLimiter struct {
+	max int
+	per time.Duration
+
+	mu     sync.Mutex
+	events map[string][]time.Time
+}
+
+func newRateLimiter(max int, per time.Duration) *rateLimiter {
+	return &rateLimiter{
+		max:    max,
+		per:    per,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// Allow records an event for this key and returns true, unless the key has already had `max` events in the last
+// `per`, in which case it returns false and nothing is recorded.
+func (l *rateLimiter) Allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// forget about anything too old to count
+	since := now.Add(-l.per)
+	recent := l.events[key][:0]
+	for _, t := range l.events[key] {
+		if t.After(since) {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= l.max {
+		l.events[key] = recent
+		return false
+	}
+
+	l.events[key] = append(recent, now)
+	return true
+}
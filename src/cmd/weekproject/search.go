@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+	"github.com/chilts/rod"
+)
+
+// The search index is an inverted index kept in Bolt :
+//
+//   index.term.<term> : key is a doc key, value is a Posting
+//   index.doc         : key is a doc key, value is a JSON list of the terms it was indexed under
+//
+// A doc key is "<userName>/<projectName>" for a project or "<userName>/<projectName>/<updateId>" for an update. Every
+// doc is indexed whatever it's visibility, since that can change later, so visibility is checked at search time.
+
+// searchLimit is the most results a search returns.
+var searchLimit = 50
+
+// how much more a term in a project's title counts than one in it's content
+const titleWeight = 3
+
+// Posting is one doc in a term's list, with how many times the term appears in it and when the doc was last changed.
+type Posting struct {
+	Count int       `json:"n"`
+	Time  time.Time `json:"t"`
+}
+
+// SearchResult is a project, or one of it's updates, which matched a search.
+type SearchResult struct {
+	Project *Project
+	Update  *Update // nil if it was the project itself which matched
+	Score   float64
+}
+
+// stopWords are too common to be worth indexing.
+var stopWords = map[string]bool{
+	"an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "but": true, "by": true, "for": true,
+	"if": true, "in": true, "into": true, "is": true, "it": true, "no": true, "not": true, "of": true, "on": true,
+	"or": true, "so": true, "that": true, "the": true, "their": true, "then": true, "there": true, "these": true,
+	"they": true, "this": true, "to": true, "was": true, "will": true, "with": true, "my": true, "i": true,
+}
+
+// tokenize splits text into lowercase terms of letters and digits, without any stop words. Since terms only contain
+// letters and digits they're safe to use as bucket names.
+func tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	terms := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if utf8.RuneCountInString(f) < 2 || len(f) > 64 || stopWords[f] {
+			continue
+		}
+		terms = append(terms, f)
+	}
+	return terms
+}
+
+// uniqueTerms removes any repeated terms, keeping the first of each.
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			unique = append(unique, term)
+		}
+	}
+	return unique
+}
+
+// countTerms adds each term in `text` to `counts`, `weight` times.
+func countTerms(counts map[string]int, text string, weight int) map[string]int {
+	for _, term := range tokenize(text) {
+		counts[term] += weight
+	}
+	return counts
+}
+
+func projectDocKey(p *Project) string {
+	return p.UserName + "/" + p.Name
+}
+
+func updateDocKey(p *Project, u *Update) string {
+	return p.UserName + "/" + p.Name + "/" + u.Id
+}
+
+// indexProject (re)indexes the project's title and content, within the caller's transaction.
+func indexProject(tx *bolt.Tx, p *Project) error {
+	counts := make(map[string]int)
+	countTerms(counts, p.Title, titleWeight)
+	countTerms(counts, p.Content, 1)
+
+	when := p.Updated
+	if when.IsZero() {
+		when = p.Inserted
+	}
+	return indexDoc(tx, projectDocKey(p), counts, when)
+}
+
+// indexUpdate (re)indexes the update's status, within the caller's transaction.
+func indexUpdate(tx *bolt.Tx, p *Project, u *Update) error {
+	return indexDoc(tx, updateDocKey(p, u), countTerms(make(map[string]int), u.Status, 1), u.Inserted)
+}
+
+// indexDoc replaces whatever was indexed for this doc with these term counts.
+func indexDoc(tx *bolt.Tx, key string, counts map[string]int, when time.Time) error {
+	err := unindexDoc(tx, key)
+	if err != nil {
+		return err
+	}
+
+	terms := make([]string, 0, len(counts))
+	for term, count := range counts {
+		err = rod.PutJson(tx, "index.term."+term, key, Posting{count, when})
+		if err != nil {
+			return err
+		}
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+
+	return rod.PutJson(tx, "index.doc", key, terms)
+}
+
+// unindexDoc removes this doc from every term it was indexed under.
+func unindexDoc(tx *bolt.Tx, key string) error {
+	terms := []string{}
+	err := rod.GetJson(tx, "index.doc", key, &terms)
+	if err != nil {
+		return err
+	}
+
+	for _, term := range terms {
+		b, err := rod.GetBucket(tx, "index.term."+term)
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			continue
+		}
+		err = b.Delete([]byte(key))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// searchScore ranks a doc by how often the terms appear in it (with diminishing returns), and then by how recently it
+// changed, so that a fresh update beats a stale one with the same words.
+func searchScore(postings []Posting, now time.Time) float64 {
+	score := 0.0
+	latest := time.Time{}
+	for _, posting := range postings {
+		score += 1 + math.Log(float64(posting.Count))
+		if posting.Time.After(latest) {
+			latest = posting.Time
+		}
+	}
+
+	days := now.Sub(latest).Hours() / 24
+	if days < 0 {
+		days = 0
+	}
+	return score / (1 + days/7)
+}
+
+// SelSearch returns the projects and updates which contain every term in `q`, best first. Only those `user` can see
+// are returned, and unlisted projects only show up for their owner and collaborators, since a search can't have the
+// share token.
+func SelSearch(db *bolt.DB, q string, user *User, now time.Time) ([]*SearchResult, error) {
+	results := make([]*SearchResult, 0)
+
+	terms := uniqueTerms(tokenize(q))
+	if len(terms) == 0 {
+		return results, nil
+	}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		// every term must match, so start with the first term's postings and look each doc up in the rest
+		buckets := make([]*bolt.Bucket, 0, len(terms))
+		for _, term := range terms {
+			b, err := rod.GetBucket(tx, "index.term."+term)
+			if err != nil || b == nil {
+				return err
+			}
+			buckets = append(buckets, b)
+		}
+
+		projects := make(map[string]*Project)
+		return buckets[0].ForEach(func(key, val []byte) error {
+			postings := make([]Posting, 0, len(buckets))
+			for _, b := range buckets {
+				raw := b.Get(key)
+				if raw == nil {
+					return nil
+				}
+				posting := Posting{}
+				if err := json.Unmarshal(raw, &posting); err != nil {
+					return nil
+				}
+				postings = append(postings, posting)
+			}
+
+			result, err := loadSearchResult(tx, string(key), projects)
+			if err != nil || result == nil {
+				return err
+			}
+			if !result.Project.CanView(user, "") {
+				return nil
+			}
+
+			result.Score = searchScore(postings, now)
+			results = append(results, result)
+			return nil
+		})
+	})
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > searchLimit {
+		results = results[:searchLimit]
+	}
+
+	return results, err
+}
+
+// loadSearchResult reads the project (and update) for this doc key. The projects are cached, since many updates from
+// the same project often match. It returns nil if the doc has gone.
+func loadSearchResult(tx *bolt.Tx, key string, projects map[string]*Project) (*SearchResult, error) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 2 {
+		return nil, nil
+	}
+	location := "user." + parts[0] + ".project." + parts[1]
+
+	p, ok := projects[parts[0]+"/"+parts[1]]
+	if !ok {
+		p = &Project{}
+		err := rod.GetJson(tx, location, "meta", p)
+		if err != nil {
+			return nil, err
+		}
+		projects[parts[0]+"/"+parts[1]] = p
+	}
+	if p.Name == "" {
+		return nil, nil
+	}
+
+	result := &SearchResult{Project: p}
+	if len(parts) == 3 {
+		u := &Update{}
+		err := rod.GetJson(tx, location+".update", parts[2], u)
+		if err != nil {
+			return nil, err
+		}
+		if u.Id == "" {
+			return nil, nil
+		}
+		result.Update = u
+	}
+
+	return result, nil
+}
+
+// RebuildIndex throws away the search index and indexes every project and update again, all in one transaction. It
+// returns how many docs were indexed.
+func RebuildIndex(db *bolt.DB) (int, error) {
+	n := 0
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("index")) != nil {
+			err := tx.DeleteBucket([]byte("index"))
+			if err != nil {
+				return err
+			}
+		}
+
+		users := tx.Bucket([]byte("user"))
+		if users == nil {
+			return nil
+		}
+
+		// collect everything first, since we mustn't add buckets while iterating
+		projects := make([]*Project, 0)
+		updates := make(map[*Project][]*Update)
+		err := users.ForEach(func(userName, v []byte) error {
+			pb, err := rod.GetBucket(tx, "user."+string(userName)+".project")
+			if err != nil || pb == nil {
+				return err
+			}
+
+			return pb.ForEach(func(projectName, v []byte) error {
+				location := "user." + string(userName) + ".project." + string(projectName)
+				p := &Project{}
+				err := rod.GetJson(tx, location, "meta", p)
+				if err != nil || p.Name == "" {
+					// CheckIntegrity reports these
+					return nil
+				}
+				projects = append(projects, p)
+
+				ub, err := rod.GetBucket(tx, location+".update")
+				if err != nil || ub == nil {
+					return err
+				}
+				return ub.ForEach(func(key, val []byte) error {
+					u := &Update{}
+					if json.Unmarshal(val, u) == nil {
+						updates[p] = append(updates[p], u)
+					}
+					return nil
+				})
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, p := range projects {
+			err = indexProject(tx, p)
+			if err != nil {
+				return err
+			}
+			n++
+			for _, u := range updates[p] {
+				err = indexUpdate(tx, p, u)
+				if err != nil {
+					return err
+				}
+				n++
+			}
+		}
+
+		return nil
+	})
+
+	return n, err
+}
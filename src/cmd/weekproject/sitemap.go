@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/chilts/rod"
+)
+
+// The sitemap is split into shards of this many users each, listed by a sitemap index at /sitemap.xml. A shard never
+// has more than sitemapMaxUrls URLs, since that's the most a sitemap may have, so any users after that are left out.
+// It's a var so the tests can use smaller shards.
+var sitemapUsersPerShard = 500
+
+const sitemapMaxUrls = 50000
+
+// how long search engines can cache the sitemap and robots.txt
+const sitemapMaxAge = time.Hour
+
+const sitemapNs = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// SitemapUrl is one page in a sitemap.
+type SitemapUrl struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// Sitemap is one shard of the sitemap.
+type Sitemap struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Urls    []SitemapUrl `xml:"url"`
+}
+
+// SitemapRef is one shard, as listed in the sitemap index.
+type SitemapRef struct {
+	Loc string `xml:"loc"`
+}
+
+// SitemapIndex lists every shard of the sitemap.
+type SitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []SitemapRef `xml:"sitemap"`
+}
+
+// sitemapShards is how many shards the sitemap has, which is always at least one, since the first also has the home
+// page.
+func sitemapShards(db *bolt.DB) (int, error) {
+	users := 0
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("user"))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			users++
+			return nil
+		})
+	})
+
+	shards := (users + sitemapUsersPerShard - 1) / sitemapUsersPerShard
+	if shards < 1 {
+		shards = 1
+	}
+	return shards, err
+}
+
+// NewSitemapIndex lists each of the `shards` shards of the sitemap, which are numbered from 1.
+func NewSitemapIndex(baseUrl string, shards int) SitemapIndex {
+	index := SitemapIndex{Xmlns: sitemapNs}
+	for i := 1; i <= shards; i++ {
+		index.Sitemaps = append(index.Sitemaps, SitemapRef{Loc: fmt.Sprintf("%s/sitemap-%d.xml", baseUrl, i)})
+	}
+	return index
+}
+
+// SelSitemap returns shard `n` (from 1) of the sitemap, with the public projects of that shard's users and the
+// profiles of those who have any. Users are taken in the order they're kept in the store, so a user always stays in
+// the same shard until more users sign up before them. Projects which can't be read are skipped, since CheckIntegrity
+// reports them.
+func SelSitemap(db *bolt.DB, baseUrl string, n int) (Sitemap, error) {
+	sitemap := Sitemap{Xmlns: sitemapNs, Urls: make([]SitemapUrl, 0)}
+	if n == 1 {
+		sitemap.Urls = append(sitemap.Urls, SitemapUrl{Loc: baseUrl + "/"}, SitemapUrl{Loc: baseUrl + "/t/"})
+	}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte("user"))
+		if users == nil {
+			return nil
+		}
+
+		// skip to this shard's first user
+		c := users.Cursor()
+		userName, _ := c.First()
+		for i := 0; userName != nil && i < (n-1)*sitemapUsersPerShard; i++ {
+			userName, _ = c.Next()
+		}
+
+		for i := 0; userName != nil && i < sitemapUsersPerShard; i++ {
+			urls, err := sitemapUser(tx, baseUrl, string(userName))
+			if err != nil {
+				return err
+			}
+			if len(sitemap.Urls)+len(urls) > sitemapMaxUrls {
+				// better to leave some out than to have search engines throw the whole shard away
+				slog.Warn("sitemap shard is full", "shard", n, "userName", string(userName), "max", sitemapMaxUrls)
+				return nil
+			}
+			sitemap.Urls = append(sitemap.Urls, urls...)
+			userName, _ = c.Next()
+		}
+
+		return nil
+	})
+
+	return sitemap, err
+}
+
+// sitemapUser returns the URLs of this user's public projects, preceded by their profile if they have any. The
+// profile was last modified when the latest of those projects was.
+func sitemapUser(tx *bolt.Tx, baseUrl, userName string) ([]SitemapUrl, error) {
+	urls := make([]SitemapUrl, 0)
+
+	pb, err := rod.GetBucket(tx, "user."+userName+".project")
+	if err != nil || pb == nil {
+		return urls, err
+	}
+
+	latest := time.Time{}
+	err = pb.ForEach(func(projectName, v []byte) error {
+		b := pb.Bucket(projectName)
+		if b == nil {
+			return nil
+		}
+		raw := b.Get([]byte("meta"))
+		if raw == nil {
+			return nil
+		}
+		p := Project{}
+		if json.Unmarshal(raw, &p) != nil || !p.IsPublic() {
+			return nil
+		}
+
+		updated := p.Updated
+		if updated.IsZero() {
+			updated = p.Inserted
+		}
+		if updated.After(latest) {
+			latest = updated
+		}
+		urls = append(urls, SitemapUrl{Loc: baseUrl + escapePath(p.Url()), LastMod: sitemapTime(updated)})
+		return nil
+	})
+	if err != nil || len(urls) == 0 {
+		return urls, err
+	}
+
+	profile := SitemapUrl{Loc: baseUrl + escapePath("/u/"+userName+"/"), LastMod: sitemapTime(latest)}
+	return append([]SitemapUrl{profile}, urls...), nil
+}
+
+// sitemapTime formats a time as a sitemap's lastmod, or empty if it isn't known.
+func sitemapTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// robotsTxt keeps crawlers out of anything which needs signing in, or which is an action rather than a page, and
+// points them at the sitemap.
+func robotsTxt(baseUrl string) string {
+	disallow := []string{
+		"/p/", "/auth/", "/logout", "/dashboard", "/settings", "/webhooks", "/api/", "/search", "/metrics",
+		"/u/*/p/*/update", "/u/*/p/*/comment", "/u/*/follow",
+	}
+
+	lines := []string{"User-agent: *"}
+	for _, path := range disallow {
+		lines = append(lines, "Disallow: "+path)
+	}
+	lines = append(lines, "", "Sitemap: "+baseUrl+"/sitemap.xml", "")
+	return strings.Join(lines, "\n")
+}
+
+// writeXml writes `v` as an XML document, which can be cached for sitemapMaxAge.
+func writeXml(w http.ResponseWriter, r *http.Request, v interface{}) {
+	out, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(sitemapMaxAge.Seconds())))
+	w.Write([]byte(xml.Header))
+	w.Write(out)
+	w.Write([]byte("\n"))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSelSitemapShards(t *testing.T) {
+	db := testDB(t)
+
+	old := sitemapUsersPerShard
+	sitemapUsersPerShard = 2
+	t.Cleanup(func() { sitemapUsersPerShard = old })
+
+	for i := 1; i <= 5; i++ {
+		userName := fmt.Sprintf("user%d", i)
+		testUser(t, db, userName)
+		testProject(t, db, userName, "Learn Go", VisibilityPublic)
+		testProject(t, db, userName, "Learn Rust", VisibilityPrivate)
+	}
+
+	shards, err := sitemapShards(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shards != 3 {
+		t.Fatalf("shards = %d, want 3", shards)
+	}
+
+	index := NewSitemapIndex("https://example.com", shards)
+	if len(index.Sitemaps) != 3 || index.Sitemaps[2].Loc != "https://example.com/sitemap-3.xml" {
+		t.Errorf("index = %+v, want the 3 shards", index.Sitemaps)
+	}
+
+	// every user's profile and public project is in exactly one shard, and only the first has the home page
+	seen := make(map[string]int)
+	for n := 1; n <= shards; n++ {
+		sitemap, err := SelSitemap(db, "https://example.com", n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range sitemap.Urls {
+			seen[u.Loc]++
+			if u.Loc == "https://example.com/" && n != 1 {
+				t.Errorf("shard %d has the home page", n)
+			}
+			if strings.Contains(u.Loc, "learn-rust") {
+				t.Errorf("shard %d has the private project %s", n, u.Loc)
+			}
+		}
+	}
+	for i := 1; i <= 5; i++ {
+		for _, loc := range []string{
+			fmt.Sprintf("https://example.com/u/user%d/", i),
+			fmt.Sprintf("https://example.com/u/user%d/p/learn-go/", i),
+		} {
+			if seen[loc] != 1 {
+				t.Errorf("%s is in %d shards, want 1", loc, seen[loc])
+			}
+		}
+	}
+
+	if len(seen) != 2+5*2 {
+		t.Errorf("got %d URLs, want %d", len(seen), 2+5*2)
+	}
+}
+
+func TestSitemapEscapesUrls(t *testing.T) {
+	db := testDB(t)
+	testUser(t, db, "chilts")
+	testProject(t, db, "chilts", "学习中文", VisibilityPublic)
+
+	want := "https://example.com/u/chilts/p/%E5%AD%A6%E4%B9%A0%E4%B8%AD%E6%96%87/"
+
+	sitemap, err := SelSitemap(db, "https://example.com", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, u := range sitemap.Urls {
+		found = found || u.Loc == want
+	}
+	if !found {
+		t.Errorf("sitemap = %+v, want it to have %s", sitemap.Urls, want)
+	}
+}
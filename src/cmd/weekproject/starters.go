@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Starter is a curated template for a new project, with a goal for each day of the week.
+type Starter struct {
+	Slug    string // e.g. "learn-a-language", as used in `/p/new?starter=...`
+	Title   string
+	Summary string
+	Content string
+	Tags    []string
+	Goals   []string // one per day
+}
+
+// Starters are the curated templates offered on `/p/new`.
+var Starters = []Starter{
+	{
+		Slug:    "learn-a-language",
+		Title:   "Learn a New Programming Language",
+		Summary: "Go from hello world to a small working program in a language you've never used.",
+		Content: "This week I'm going to learn a new programming language well enough to write something useful in it.",
+		Tags:    []string{"programming", "learning"},
+		Goals: []string{
+			"Install the toolchain and write hello world",
+			"Work through the official tour or tutorial",
+			"Learn the standard library's collections and strings",
+			"Write a small command line tool",
+			"Add tests and learn the package manager",
+			"Read some idiomatic open source code",
+			"Write up what I liked and what I didn't",
+		},
+	},
+	{
+		Slug:    "ship-a-side-project",
+		Title:   "Ship a Side Project",
+		Summary: "Take a small idea all the way to something other people can use.",
+		Content: "This week I'm going to build and ship a small side project, and put it in front of real people.",
+		Tags:    []string{"side-project", "shipping"},
+		Goals: []string{
+			"Write down the idea and cut it down to one feature",
+			"Sketch the screens and the data",
+			"Build the core feature",
+			"Build the rest of the minimum",
+			"Deploy it somewhere public",
+			"Polish the rough edges and write the README",
+			"Launch it and tell people",
+		},
+	},
+	{
+		Slug:    "write-every-day",
+		Title:   "Write Every Day",
+		Summary: "Build a writing habit with a short piece each day, and publish the best one.",
+		Content: "This week I'm going to write something every day, and publish my favourite piece at the end.",
+		Tags:    []string{"writing", "habits"},
+		Goals: []string{
+			"Write 300 words about anything",
+			"Write 300 words about something I learned recently",
+			"Write a short how-to",
+			"Write an opinion piece",
+			"Edit my favourite piece so far",
+			"Get feedback from a friend",
+			"Publish it",
+		},
+	},
+}
+
+// GetStarter returns the starter with this slug, or nil.
+func GetStarter(slug string) *Starter {
+	for i := range Starters {
+		if Starters[i].Slug == slug {
+			return &Starters[i]
+		}
+	}
+	return nil
+}
+
+// Project returns a new, unsaved, project filled in from the starter, with a goal for each day. Progress is worked out
+// from the goals.
+func (s *Starter) Project() *Project {
+	goals := make([]Goal, 0, len(s.Goals))
+	for i, title := range s.Goals {
+		goals = append(goals, Goal{Id: fmt.Sprintf("%s-%d", s.Slug, i+1), Title: title, Day: i + 1})
+	}
+
+	return &Project{
+		Title:        s.Title,
+		Content:      s.Content,
+		Tags:         s.Tags,
+		Goals:        goals,
+		AutoProgress: true,
+	}
+}
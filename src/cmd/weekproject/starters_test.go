@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestGetStarter(t *testing.T) {
+	for _, s := range Starters {
+		got := GetStarter(s.Slug)
+		if got == nil || got.Slug != s.Slug {
+			t.Fatalf("GetStarter(%q) = %+v", s.Slug, got)
+		}
+
+		p := got.Project()
+		if p.Title != s.Title || !p.AutoProgress || p.Progress != 0 || len(p.Goals) != 7 {
+			t.Errorf("%s: project = %+v, want a goal a day and AutoProgress", s.Slug, p)
+		}
+		ids := make(map[string]bool)
+		for i, g := range p.Goals {
+			if g.Day != i+1 || g.Done || ids[g.Id] {
+				t.Errorf("%s: goal %d = %+v", s.Slug, i, g)
+			}
+			ids[g.Id] = true
+		}
+		p.UserName = "chilts"
+		if !p.Validate() {
+			t.Errorf("%s: project isn't valid: %v", s.Slug, p.Error)
+		}
+	}
+
+	for _, slug := range []string{"", "nope", "Learn-A-Language"} {
+		if got := GetStarter(slug); got != nil {
+			t.Errorf("GetStarter(%q) = %+v, want nil", slug, got)
+		}
+	}
+}
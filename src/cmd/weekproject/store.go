@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"sort"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -12,8 +15,43 @@ import (
 
 var (
 	ErrLocationMustHaveOneBucket = errors.New("location must specify at least one bucket")
+	ErrMissingMeta               = errors.New("bucket has no meta record")
+	ErrOrphanedUpdates           = errors.New("update bucket belongs to a project with no meta record")
+	ErrExpectedBucket            = errors.New("expected a bucket, found a value")
+	ErrExpectedValue             = errors.New("expected a value, found a bucket")
+	ErrNoSuchUpdate              = errors.New("no such update")
 )
 
+// RecordError describes a single record in the store which could not be read. Selects which range over many records
+// collect these rather than giving up, so that one bad record doesn't hide all the good ones after it.
+type RecordError struct {
+	Location string // e.g. "user.chilts.project.week-project"
+	Key      string // e.g. "meta"
+	Err      error
+}
+
+func (e *RecordError) Error() string {
+	return e.Location + ":" + e.Key + " : " + e.Err.Error()
+}
+
+// newRecordError creates a RecordError. It isn't logged here, since the caller reports it: pages and jobs with
+// logBadRecords, and the integrity command with it's own report.
+func newRecordError(location, key string, err error) *RecordError {
+	return &RecordError{
+		Location: location,
+		Key:      key,
+		Err:      err,
+	}
+}
+
+// logBadRecords logs each record a select couldn't read, once, with the request (or job) which came across it. The
+// page or job carries on with the records which could be read.
+func logBadRecords(lg *slog.Logger, bad []*RecordError) {
+	for _, e := range bad {
+		lg.Warn("bad record", "location", e.Location, "key", e.Key, "err", e.Err)
+	}
+}
+
 func InsSocial(db *bolt.DB, social Social) (Social, error) {
 	// generate some fields
 	now := time.Now().UTC()
@@ -56,17 +94,74 @@ func InsUser(db *bolt.DB, user User) (User, error) {
 	return u, err
 }
 
-// InsProject takes a project and it into the store. It doesn't set or manipulate any fields on the project prior to
-// insert. It fails if this project already exists (under this user).
+// InsProject takes a project and puts it into the store, replacing it if it already exists (under this user). It
+// doesn't set or manipulate any fields on the project prior to insert. Use InsNewProject for a new project, so that
+// it doesn't replace another one with the same name.
 //
-// We only use the Title, Content, and UserName fields. The rest are generated.
+// The project is (re)indexed for search and it's tags in the same transaction.
 func InsProject(db *bolt.DB, p Project) error {
 	return db.Update(func(tx *bolt.Tx) error {
-		location := "user." + p.UserName + ".project." + p.Name
-		return rod.PutJson(tx, location, "meta", p)
+		return putProject(tx, &p)
+	})
+}
+
+// InsNewProject puts a new project into the store under the first of it's Name, then "<name>-2", "<name>-3", etc which
+// isn't reserved or already taken by this user, setting p.Name to the one used.
+func InsNewProject(db *bolt.DB, p *Project) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		name, err := freeProjectName(tx, p.UserName, p.Name)
+		if err != nil {
+			return err
+		}
+		p.Name = name
+		return putProject(tx, p)
 	})
 }
 
+// freeProjectName returns `name`, or `name` with the lowest suffix, which this user can use for a new project.
+func freeProjectName(tx *bolt.Tx, userName, name string) (string, error) {
+	b, err := rod.GetBucket(tx, "user."+userName+".project")
+	if err != nil {
+		return "", err
+	}
+
+	for i := 1; i <= 1000; i++ {
+		candidate := name
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d", name, i)
+		}
+		if reservedNames[candidate] {
+			continue
+		}
+		if b == nil || b.Bucket([]byte(candidate)) == nil {
+			return candidate, nil
+		}
+	}
+
+	return "", fmt.Errorf("no free name for project %s/%s", userName, name)
+}
+
+// putProject puts the project into the store and (re)indexes it, within the caller's transaction.
+func putProject(tx *bolt.Tx, p *Project) error {
+	location := "user." + p.UserName + ".project." + p.Name
+
+	// the project as it was, so we know which tags it's lost, which is nothing if it can't be read
+	old := Project{}
+	if rod.GetJson(tx, location, "meta", &old) != nil {
+		old = Project{}
+	}
+
+	err := rod.PutJson(tx, location, "meta", p)
+	if err != nil {
+		return err
+	}
+	err = indexTags(tx, &old, p)
+	if err != nil {
+		return err
+	}
+	return indexProject(tx, p)
+}
+
 // GetProject
 func GetProject(db *bolt.DB, userName, projectName string) (Project, error) {
 	p := Project{}
@@ -78,9 +173,183 @@ func GetProject(db *bolt.DB, userName, projectName string) (Project, error) {
 	return p, err
 }
 
-// SelProjects returns a splice of projects for this userName.
-func SelProjects(db *bolt.DB, userName string) ([]*Project, error) {
+// GetUser returns the user with this name. If there is no such user, the returned user has an empty Name.
+func GetUser(db *bolt.DB, userName string) (User, error) {
+	u := User{}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		return rod.GetJson(tx, "user."+userName, "meta", &u)
+	})
+
+	return u, err
+}
+
+// AddMember adds a collaborator to the project, and records the membership under the collaborator too so that they
+// can find the project again. Both happen in the same transaction.
+func AddMember(db *bolt.DB, p Project, userName string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		location := "user." + p.UserName + ".project." + p.Name
+
+		// re-read the project in this tx, so we don't lose any other changes
+		current := Project{}
+		err := rod.GetJson(tx, location, "meta", &current)
+		if err != nil {
+			return err
+		}
+		if current.IsMember(userName) {
+			return nil
+		}
+		current.Members = append(current.Members, userName)
+
+		err = rod.PutJson(tx, location, "meta", current)
+		if err != nil {
+			return err
+		}
+
+		m := Membership{
+			UserName:    p.UserName,
+			ProjectName: p.Name,
+			Inserted:    time.Now().UTC(),
+		}
+		return rod.PutJson(tx, "user."+userName+".member", m.Key(), m)
+	})
+}
+
+// DelMember removes a collaborator from the project, along with their record of the membership.
+func DelMember(db *bolt.DB, p Project, userName string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		location := "user." + p.UserName + ".project." + p.Name
+
+		current := Project{}
+		err := rod.GetJson(tx, location, "meta", &current)
+		if err != nil {
+			return err
+		}
+
+		members := make([]string, 0, len(current.Members))
+		for _, name := range current.Members {
+			if name != userName {
+				members = append(members, name)
+			}
+		}
+		current.Members = members
+
+		err = rod.PutJson(tx, location, "meta", current)
+		if err != nil {
+			return err
+		}
+
+		b, err := rod.GetBucket(tx, "user."+userName+".member")
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return nil
+		}
+		m := Membership{UserName: p.UserName, ProjectName: p.Name}
+		return b.Delete([]byte(m.Key()))
+	})
+}
+
+// InsGoal adds a goal to the project's checklist. Like any other change to the project, it moves Updated on.
+func InsGoal(db *bolt.DB, p Project, g Goal) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		location := "user." + p.UserName + ".project." + p.Name
+
+		// re-read the project in this tx, so we don't lose any other changes
+		current := Project{}
+		err := rod.GetJson(tx, location, "meta", &current)
+		if err != nil {
+			return err
+		}
+		if current.Name == "" {
+			return ErrMissingMeta
+		}
+		current.Goals = append(current.Goals, g)
+		current.Updated = time.Now().UTC()
+
+		return putProject(tx, &current)
+	})
+}
+
+// DelGoal removes a goal from the project's checklist, moving Updated on.
+func DelGoal(db *bolt.DB, p Project, id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		location := "user." + p.UserName + ".project." + p.Name
+
+		current := Project{}
+		err := rod.GetJson(tx, location, "meta", &current)
+		if err != nil {
+			return err
+		}
+
+		if current.Name == "" {
+			return ErrMissingMeta
+		}
+
+		goals := make([]Goal, 0, len(current.Goals))
+		for _, g := range current.Goals {
+			if g.Id != id {
+				goals = append(goals, g)
+			}
+		}
+		current.Goals = goals
+		current.Updated = time.Now().UTC()
+
+		return putProject(tx, &current)
+	})
+}
+
+// SelMemberProjects returns a splice of the projects this userName collaborates on, i.e. those owned by other users.
+// As with SelProjects, any which can't be read are returned as record errors.
+func SelMemberProjects(db *bolt.DB, userName string) ([]*Project, []*RecordError, error) {
+	projects := make([]*Project, 0)
+	bad := make([]*RecordError, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		location := "user." + userName + ".member"
+		b, err := rod.GetBucket(tx, location)
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for key, val := c.First(); key != nil; key, val = c.Next() {
+			m := Membership{}
+			err := json.Unmarshal(val, &m)
+			if err != nil {
+				bad = append(bad, newRecordError(location, string(key), err))
+				continue
+			}
+
+			projectLocation := "user." + m.UserName + ".project." + m.ProjectName
+			p := Project{}
+			err = rod.GetJson(tx, projectLocation, "meta", &p)
+			if err != nil {
+				bad = append(bad, newRecordError(projectLocation, "meta", err))
+				continue
+			}
+			if p.Name == "" || !p.IsMember(userName) {
+				// the project has gone, or they've been removed from it
+				continue
+			}
+			projects = append(projects, &p)
+		}
+
+		return nil
+	})
+
+	return projects, bad, err
+}
+
+// SelProjects returns a splice of projects for this userName. Any projects which can't be read are skipped and
+// returned in the splice of record errors, so the caller still gets every good project.
+func SelProjects(db *bolt.DB, userName string) ([]*Project, []*RecordError, error) {
 	projects := make([]*Project, 0)
+	bad := make([]*RecordError, 0)
 
 	err := db.View(func(tx *bolt.Tx) error {
 		// range over this user's projects
@@ -96,10 +365,16 @@ func SelProjects(db *bolt.DB, userName string) ([]*Project, error) {
 		c := b.Cursor()
 		for name, _ := c.First(); name != nil; name, _ = c.Next() {
 			// get this project
+			location := "user." + userName + ".project." + string(name)
 			p := Project{}
-			err := rod.GetJson(tx, "user."+userName+".project."+string(name), "meta", &p)
+			err := rod.GetJson(tx, location, "meta", &p)
 			if err != nil {
-				return nil
+				bad = append(bad, newRecordError(location, "meta", err))
+				continue
+			}
+			if p.Name == "" {
+				bad = append(bad, newRecordError(location, "meta", ErrMissingMeta))
+				continue
 			}
 			projects = append(projects, &p)
 		}
@@ -107,39 +382,193 @@ func SelProjects(db *bolt.DB, userName string) ([]*Project, error) {
 		return nil
 	})
 
-	return projects, err
+	return projects, bad, err
 }
 
 // InsUpdate takes an update and a project and puts it into the store. It doesn't set or manipulate any fields on the
-// project prior to insert. It uses an id based on the u.Inserted time.
+// update prior to insert, but copies the update's progress and time, and which of `p`'s goals on the form (u.Goals)
+// are done, onto the stored project (so the caller should tick them off first, with CheckGoals). It uses an id based
+// on the u.Inserted time. The project is re-read, and the update stored, indexed for search and it's blobs' owners recorded, all in the
+// same transaction, so that two people posting at once can't lose each other's changes.
 func InsUpdate(db *bolt.DB, p Project, u Update) error {
-	// firstly, get the project out, then update the progress
-	p, errGet := GetProject(db, p.UserName, p.Name)
-	if errGet != nil {
-		return errGet
+	return db.Update(func(tx *bolt.Tx) error {
+		location := "user." + p.UserName + ".project." + p.Name
+
+		// re-read the project in this tx, then update the progress and which goals are done
+		current := Project{}
+		err := rod.GetJson(tx, location, "meta", &current)
+		if err != nil {
+			return err
+		}
+		if current.Name == "" {
+			return ErrMissingMeta
+		}
+		current.Progress = u.Progress
+		current.Updated = u.Inserted
+		current.SetGoalStates(shownGoals(p.Goals, u.Goals))
+
+		err = putProject(tx, &current)
+		if err != nil {
+			return err
+		}
+
+		err = rod.PutJson(tx, location+".update", u.Id, u)
+		if err != nil {
+			return err
+		}
+		err = putBlobOwners(tx, &current, &u)
+		if err != nil {
+			return err
+		}
+		return indexUpdate(tx, &current, &u)
+	})
+}
+
+// shownGoals returns those of `goals` whose ids are in `shown`, so that an update only changes the goals it's poster
+// could see, and not ones someone else has ticked off or added since.
+func shownGoals(goals []Goal, shown []string) []Goal {
+	isShown := make(map[string]bool)
+	for _, id := range shown {
+		isShown[id] = true
 	}
 
-	p.Progress = u.Progress
+	out := make([]Goal, 0, len(shown))
+	for _, g := range goals {
+		if isShown[g.Id] {
+			out = append(out, g)
+		}
+	}
+	return out
+}
 
-	errIns := InsProject(db, p)
-	if errIns != nil {
-		return errIns
+// putBlobOwners records that the update's attachments (and their thumbnails) belong to it's project, within the
+// caller's transaction.
+func putBlobOwners(tx *bolt.Tx, p *Project, u *Update) error {
+	owner := BlobOwner{UserName: p.UserName, ProjectName: p.Name}
+	for _, a := range u.Attachments {
+		for _, key := range []string{a.Key, a.Thumb} {
+			if !validBlobKey(key) {
+				continue
+			}
+			err := rod.PutJson(tx, "blob."+key, owner.UserName+"/"+owner.ProjectName, owner)
+			if err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	return db.Update(func(tx *bolt.Tx) error {
+// RebuildBlobOwners throws away the record of which projects own each blob and records it again from every update, all
+// in one transaction. It returns how many blobs have owners.
+func RebuildBlobOwners(db *bolt.DB) (int, error) {
+	keys := make(map[string]bool)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("blob")) != nil {
+			err := tx.DeleteBucket([]byte("blob"))
+			if err != nil {
+				return err
+			}
+		}
+
+		users := tx.Bucket([]byte("user"))
+		if users == nil {
+			return nil
+		}
+
+		// collect everything first, since we mustn't add buckets while iterating
+		projects := make([]*Project, 0)
+		updates := make(map[*Project][]*Update)
+		err := users.ForEach(func(userName, v []byte) error {
+			pb, err := rod.GetBucket(tx, "user."+string(userName)+".project")
+			if err != nil || pb == nil {
+				return err
+			}
+
+			return pb.ForEach(func(projectName, v []byte) error {
+				location := "user." + string(userName) + ".project." + string(projectName)
+				p := &Project{}
+				err := rod.GetJson(tx, location, "meta", p)
+				if err != nil || p.Name == "" {
+					// CheckIntegrity reports these
+					return nil
+				}
+				projects = append(projects, p)
+
+				ub, err := rod.GetBucket(tx, location+".update")
+				if err != nil || ub == nil {
+					return err
+				}
+				return ub.ForEach(func(key, val []byte) error {
+					u := &Update{}
+					if json.Unmarshal(val, u) == nil && len(u.Attachments) > 0 {
+						updates[p] = append(updates[p], u)
+					}
+					return nil
+				})
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, p := range projects {
+			for _, u := range updates[p] {
+				err = putBlobOwners(tx, p, u)
+				if err != nil {
+					return err
+				}
+				for _, a := range u.Attachments {
+					for _, key := range []string{a.Key, a.Thumb} {
+						if validBlobKey(key) {
+							keys[key] = true
+						}
+					}
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return len(keys), err
+}
 
-		location := "user." + p.UserName + ".project." + p.Name + ".update"
-		return rod.PutJson(tx, location, u.Id, u)
+// SelBlobOwners returns the projects which have this blob attached to an update.
+func SelBlobOwners(db *bolt.DB, key string) ([]*BlobOwner, error) {
+	owners := make([]*BlobOwner, 0)
+	if !validBlobKey(key) {
+		return owners, nil
+	}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b, err := rod.GetBucket(tx, "blob."+key)
+		if err != nil || b == nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			o := BlobOwner{}
+			if json.Unmarshal(v, &o) == nil {
+				owners = append(owners, &o)
+			}
+			return nil
+		})
 	})
+
+	return owners, err
 }
 
-// SelUpdates returns a splice of projects for this userName.
-func SelUpdates(db *bolt.DB, userName, projectName string) ([]*Update, error) {
+// SelUpdates returns a splice of updates for this user's project. As with SelProjects, any updates which can't be read
+// are skipped and returned in the splice of record errors.
+func SelUpdates(db *bolt.DB, userName, projectName string) ([]*Update, []*RecordError, error) {
 	updates := make([]*Update, 0)
+	bad := make([]*RecordError, 0)
 
 	err := db.View(func(tx *bolt.Tx) error {
 		// range over this user's project's updates
-		b, err := rod.GetBucket(tx, "user."+userName+".project."+projectName+".update")
+		location := "user." + userName + ".project." + projectName + ".update"
+		b, err := rod.GetBucket(tx, location)
 		if err != nil {
 			return err
 		}
@@ -147,15 +576,15 @@ func SelUpdates(db *bolt.DB, userName, projectName string) ([]*Update, error) {
 			return nil
 		}
 
-		// loop through all project buckets
+		// loop through all updates
 		c := b.Cursor()
 		for key, val := c.First(); key != nil; key, val = c.Next() {
-			fmt.Printf("")
 			// get this update
 			u := Update{}
 			err := json.Unmarshal(val, &u)
 			if err != nil {
-				return err
+				bad = append(bad, newRecordError(location, string(key), err))
+				continue
 			}
 			updates = append(updates, &u)
 		}
@@ -163,5 +592,858 @@ func SelUpdates(db *bolt.DB, userName, projectName string) ([]*Update, error) {
 		return nil
 	})
 
-	return updates, err
+	return updates, bad, err
+}
+
+// InsComment puts the comment into the project's comment bucket. If the comment is on an update, that update must
+// exist.
+func InsComment(db *bolt.DB, p Project, c Comment) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		location := "user." + p.UserName + ".project." + p.Name
+
+		if c.UpdateId != "" {
+			raw, err := rod.Get(tx, location+".update", c.UpdateId)
+			if err != nil {
+				return err
+			}
+			if raw == nil {
+				return ErrNoSuchUpdate
+			}
+		}
+
+		return rod.PutJson(tx, location+".comment", c.Id, c)
+	})
+}
+
+// GetComment returns the comment with this id. If there is no such comment, the returned comment has an empty Id.
+func GetComment(db *bolt.DB, userName, projectName, id string) (Comment, error) {
+	c := Comment{}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		return rod.GetJson(tx, "user."+userName+".project."+projectName+".comment", id, &c)
+	})
+
+	return c, err
+}
+
+// DelComment removes the comment with this id, if it exists.
+func DelComment(db *bolt.DB, userName, projectName, id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := rod.GetBucket(tx, "user."+userName+".project."+projectName+".comment")
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// SelComments returns a splice of all comments on this user's project, oldest first. Any comments which can't be read
+// are returned as record errors.
+func SelComments(db *bolt.DB, userName, projectName string) ([]*Comment, []*RecordError, error) {
+	comments := make([]*Comment, 0)
+	bad := make([]*RecordError, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		location := "user." + userName + ".project." + projectName + ".comment"
+		b, err := rod.GetBucket(tx, location)
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for key, val := c.First(); key != nil; key, val = c.Next() {
+			comment := Comment{}
+			err := json.Unmarshal(val, &comment)
+			if err != nil {
+				bad = append(bad, newRecordError(location, string(key), err))
+				continue
+			}
+			comments = append(comments, &comment)
+		}
+
+		return nil
+	})
+
+	return comments, bad, err
+}
+
+// kudosKey is the key in a project's `kudos` bucket recording that this user gave kudos to this update. The user comes
+// first so that we can find all of a user's kudos on a project with one seek.
+func kudosKey(userName, updateId string) string {
+	return userName + "/" + updateId
+}
+
+// ToggleKudos gives kudos to the update from this user, or takes it away if they've already given it, and returns
+// whether they now have. The count on the Update itself is kept in step in the same transaction, so nobody needs to
+// load the kudos to count them.
+func ToggleKudos(db *bolt.DB, p Project, updateId, userName string) (bool, error) {
+	given := false
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		location := "user." + p.UserName + ".project." + p.Name
+
+		u := Update{}
+		err := rod.GetJson(tx, location+".update", updateId, &u)
+		if err != nil {
+			return err
+		}
+		if u.Id == "" {
+			return ErrNoSuchUpdate
+		}
+
+		key := kudosKey(userName, updateId)
+		raw, err := rod.Get(tx, location+".kudos", key)
+		if err != nil {
+			return err
+		}
+
+		if raw == nil {
+			err = rod.PutJson(tx, location+".kudos", key, time.Now().UTC())
+			u.Kudos++
+			given = true
+		} else {
+			b, _ := rod.GetBucket(tx, location+".kudos")
+			err = b.Delete([]byte(key))
+			u.Kudos--
+		}
+		if err != nil {
+			return err
+		}
+		if u.Kudos < 0 {
+			u.Kudos = 0
+		}
+
+		return rod.PutJson(tx, location+".update", updateId, u)
+	})
+
+	return given, err
+}
+
+// SelKudosBy returns the set of update ids on this project which this user has given kudos to.
+func SelKudosBy(db *bolt.DB, p Project, userName string) (map[string]bool, error) {
+	given := make(map[string]bool)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b, err := rod.GetBucket(tx, "user."+p.UserName+".project."+p.Name+".kudos")
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return nil
+		}
+
+		prefix := []byte(kudosKey(userName, ""))
+		c := b.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			given[string(k[len(prefix):])] = true
+		}
+
+		return nil
+	})
+
+	return given, err
+}
+
+// InsFollow records that `follower` follows `followee`, under both users, in one transaction.
+func InsFollow(db *bolt.DB, follower, followee string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		now := time.Now().UTC()
+
+		err := rod.PutJson(tx, "user."+follower+".following", followee, Follow{UserName: followee, Inserted: now})
+		if err != nil {
+			return err
+		}
+		return rod.PutJson(tx, "user."+followee+".follower", follower, Follow{UserName: follower, Inserted: now})
+	})
+}
+
+// DelFollow removes both records of `follower` following `followee`.
+func DelFollow(db *bolt.DB, follower, followee string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, pair := range [][2]string{{follower + ".following", followee}, {followee + ".follower", follower}} {
+			b, err := rod.GetBucket(tx, "user."+pair[0])
+			if err != nil {
+				return err
+			}
+			if b == nil {
+				continue
+			}
+			err = b.Delete([]byte(pair[1]))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// IsFollowing says whether `follower` follows `followee`.
+func IsFollowing(db *bolt.DB, follower, followee string) (bool, error) {
+	following := false
+
+	err := db.View(func(tx *bolt.Tx) error {
+		raw, err := rod.Get(tx, "user."+follower+".following", followee)
+		following = raw != nil
+		return err
+	})
+
+	return following, err
+}
+
+// GetFollowCounts returns how many followers this user has, and how many users they follow.
+func GetFollowCounts(db *bolt.DB, userName string) (int, int, error) {
+	followers, following := 0, 0
+
+	err := db.View(func(tx *bolt.Tx) error {
+		for _, counter := range []struct {
+			location string
+			n        *int
+		}{{"user." + userName + ".follower", &followers}, {"user." + userName + ".following", &following}} {
+			b, err := rod.GetBucket(tx, counter.location)
+			if err != nil {
+				return err
+			}
+			if b == nil {
+				continue
+			}
+			*counter.n = b.Stats().KeyN
+		}
+		return nil
+	})
+
+	return followers, following, err
+}
+
+// SelFollowing returns the names of everyone this user follows.
+func SelFollowing(db *bolt.DB, userName string) ([]string, error) {
+	names := make([]string, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b, err := rod.GetBucket(tx, "user."+userName+".following")
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+
+	return names, err
+}
+
+// SelFeed returns the updates since `since` on the public projects of all of these users, newest first, and at most
+// `limit` of them. Update keys are formatted times, so we only read the recent ones.
+func SelFeed(db *bolt.DB, userNames []string, since time.Time, limit int) ([]*FeedItem, []*RecordError, error) {
+	items := make([]*FeedItem, 0)
+	bad := make([]*RecordError, 0)
+	from := []byte(since.UTC().Format(idFormat))
+
+	err := db.View(func(tx *bolt.Tx) error {
+		for _, userName := range userNames {
+			pb, err := rod.GetBucket(tx, "user."+userName+".project")
+			if err != nil {
+				return err
+			}
+			if pb == nil {
+				continue
+			}
+
+			c := pb.Cursor()
+			for name, _ := c.First(); name != nil; name, _ = c.Next() {
+				location := "user." + userName + ".project." + string(name)
+				p := Project{}
+				err := rod.GetJson(tx, location, "meta", &p)
+				if err != nil {
+					bad = append(bad, newRecordError(location, "meta", err))
+					continue
+				}
+				if p.Name == "" || !p.IsPublic() {
+					continue
+				}
+
+				ub := pb.Bucket(name).Bucket([]byte("update"))
+				if ub == nil {
+					continue
+				}
+				uc := ub.Cursor()
+				for key, val := uc.Seek(from); key != nil; key, val = uc.Next() {
+					u := Update{}
+					err := json.Unmarshal(val, &u)
+					if err != nil {
+						bad = append(bad, newRecordError(location+".update", string(key), err))
+						continue
+					}
+					items = append(items, &FeedItem{Project: &p, Update: &u})
+				}
+			}
+		}
+		return nil
+	})
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Update.Id > items[j].Update.Id
+	})
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	return items, bad, err
+}
+
+// GetPrefs returns this user's notification preferences, or the defaults if they've never set any.
+func GetPrefs(db *bolt.DB, userName string) (Prefs, error) {
+	prefs := Prefs{}
+	found := false
+
+	err := db.View(func(tx *bolt.Tx) error {
+		raw, err := rod.Get(tx, "user."+userName, "prefs")
+		if err != nil || raw == nil {
+			return err
+		}
+		found = true
+		return json.Unmarshal(raw, &prefs)
+	})
+
+	if !found {
+		return DefaultPrefs(), err
+	}
+	return prefs, err
+}
+
+// PutPrefs saves this user's notification preferences.
+func PutPrefs(db *bolt.DB, userName string, prefs Prefs) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return rod.PutJson(tx, "user."+userName, "prefs", prefs)
+	})
+}
+
+// InsMail puts a message onto the mail queue.
+func InsMail(db *bolt.DB, m QueuedMail) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return rod.PutJson(tx, "mail", m.Id, m)
+	})
+}
+
+// SelDueMail returns up to `limit` queued messages which are due to be sent at `now`.
+func SelDueMail(db *bolt.DB, now time.Time, limit int) ([]*QueuedMail, []*RecordError, error) {
+	due := make([]*QueuedMail, 0)
+	bad := make([]*RecordError, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("mail"))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for key, val := c.First(); key != nil && len(due) < limit; key, val = c.Next() {
+			m := QueuedMail{}
+			err := json.Unmarshal(val, &m)
+			if err != nil {
+				bad = append(bad, newRecordError("mail", string(key), err))
+				continue
+			}
+			if m.NextAttempt.After(now) {
+				continue
+			}
+			due = append(due, &m)
+		}
+
+		return nil
+	})
+
+	return due, bad, err
+}
+
+// UpdMail saves a queued message after a failed attempt to send it.
+func UpdMail(db *bolt.DB, m QueuedMail) error {
+	return InsMail(db, m)
+}
+
+// DelMail removes a message from the queue, once it has been sent.
+func DelMail(db *bolt.DB, id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("mail"))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// FailMail moves a message which we've given up on from the queue into the `mailfailed` bucket, so it can be looked at
+// later.
+func FailMail(db *bolt.DB, m QueuedMail) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		err := rod.PutJson(tx, "mailfailed", m.Id, m)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte("mail")).Delete([]byte(m.Id))
+	})
+}
+
+// MarkNotified records that the owner of the project has been told about this event, with a `marker` saying what
+// about (e.g. the last update). It returns false if they've already been told with this same marker, so we don't keep
+// telling them the same thing.
+func MarkNotified(db *bolt.DB, p Project, event, marker string) (bool, error) {
+	marked := false
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		location := "user." + p.UserName + ".project." + p.Name
+		key := "notified-" + event
+
+		raw, err := rod.Get(tx, location, key)
+		if err != nil {
+			return err
+		}
+		if string(raw) == marker {
+			return nil
+		}
+
+		marked = true
+		return rod.Put(tx, location, key, []byte(marker))
+	})
+
+	return marked, err
+}
+
+// SelActivity returns every project created since `since`, along with when it was last updated.
+func SelActivity(db *bolt.DB, since time.Time) ([]*Activity, []*RecordError, error) {
+	activity := make([]*Activity, 0)
+	bad := make([]*RecordError, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte("user"))
+		if users == nil {
+			return nil
+		}
+
+		return users.ForEach(func(userName, v []byte) error {
+			ub := users.Bucket(userName)
+			if ub == nil {
+				return nil
+			}
+			pb := ub.Bucket([]byte("project"))
+			if pb == nil {
+				return nil
+			}
+
+			return pb.ForEach(func(name, v []byte) error {
+				b := pb.Bucket(name)
+				if b == nil {
+					return nil
+				}
+
+				location := "user." + string(userName) + ".project." + string(name)
+				p := Project{}
+				err := json.Unmarshal(b.Get([]byte("meta")), &p)
+				if err != nil {
+					bad = append(bad, newRecordError(location, "meta", err))
+					return nil
+				}
+				if p.Inserted.Before(since) {
+					return nil
+				}
+
+				a := &Activity{Project: &p, LastUpdate: p.Inserted}
+				if updates := b.Bucket([]byte("update")); updates != nil {
+					if _, val := updates.Cursor().Last(); val != nil {
+						u := Update{}
+						if err := json.Unmarshal(val, &u); err == nil && u.Inserted.After(a.LastUpdate) {
+							a.LastUpdate = u.Inserted
+						}
+					}
+				}
+				activity = append(activity, a)
+				return nil
+			})
+		})
+	})
+
+	return activity, bad, err
+}
+
+// InsWebhook puts this webhook into the store, under it's user.
+func InsWebhook(db *bolt.DB, wh Webhook) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return rod.PutJson(tx, "user."+wh.UserName+".webhook", wh.Id, wh)
+	})
+}
+
+// GetWebhook returns this user's webhook, or an empty one if there is no such webhook.
+func GetWebhook(db *bolt.DB, userName, id string) (Webhook, error) {
+	wh := Webhook{}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		return rod.GetJson(tx, "user."+userName+".webhook", id, &wh)
+	})
+
+	return wh, err
+}
+
+// DelWebhook removes this user's webhook. It's deliveries stay in the log.
+func DelWebhook(db *bolt.DB, userName, id string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := rod.GetBucket(tx, "user."+userName+".webhook")
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+// SelWebhooks returns all of this user's webhooks, oldest first. As with SelProjects, any which can't be read are
+// skipped and returned in the splice of record errors.
+func SelWebhooks(db *bolt.DB, userName string) ([]*Webhook, []*RecordError, error) {
+	webhooks := make([]*Webhook, 0)
+	bad := make([]*RecordError, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		location := "user." + userName + ".webhook"
+		b, err := rod.GetBucket(tx, location)
+		if err != nil || b == nil {
+			return err
+		}
+
+		return b.ForEach(func(key, val []byte) error {
+			wh := Webhook{}
+			err := json.Unmarshal(val, &wh)
+			if err != nil {
+				bad = append(bad, newRecordError(location, string(key), err))
+				return nil
+			}
+			webhooks = append(webhooks, &wh)
+			return nil
+		})
+	})
+
+	return webhooks, bad, err
+}
+
+// deliveryLogLimit is how many deliveries we keep in each user's log.
+var deliveryLogLimit = 50
+
+// InsDelivery adds a new delivery to the user's log and queues it to be sent. The oldest deliveries are dropped from
+// the log, unless they're still queued.
+func InsDelivery(db *bolt.DB, d Delivery) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		location := "user." + d.UserName + ".delivery"
+		err := rod.PutJson(tx, location, d.Id, d)
+		if err != nil {
+			return err
+		}
+
+		if d.Status == DeliveryPending {
+			err = rod.Put(tx, "webhookqueue", d.Id, []byte(d.UserName))
+			if err != nil {
+				return err
+			}
+		}
+
+		// prune the log
+		b, err := rod.GetBucket(tx, location)
+		if err != nil {
+			return err
+		}
+		queue := tx.Bucket([]byte("webhookqueue"))
+		over := b.Stats().KeyN - deliveryLogLimit
+		old := make([][]byte, 0)
+		c := b.Cursor()
+		for key, _ := c.First(); key != nil && len(old) < over; key, _ = c.Next() {
+			if queue != nil && queue.Get(key) != nil {
+				continue
+			}
+			old = append(old, key)
+		}
+		for _, key := range old {
+			err = b.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// UpdDelivery saves a delivery after an attempt to send it, and takes it off the queue once it's no longer pending.
+func UpdDelivery(db *bolt.DB, d Delivery) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		err := rod.PutJson(tx, "user."+d.UserName+".delivery", d.Id, d)
+		if err != nil {
+			return err
+		}
+		if d.Status == DeliveryPending {
+			return nil
+		}
+		queue := tx.Bucket([]byte("webhookqueue"))
+		if queue == nil {
+			return nil
+		}
+		return queue.Delete([]byte(d.Id))
+	})
+}
+
+// SelDueDeliveries returns up to `limit` queued deliveries which are due to be sent at `now`.
+func SelDueDeliveries(db *bolt.DB, now time.Time, limit int) ([]*Delivery, []*RecordError, error) {
+	due := make([]*Delivery, 0)
+	bad := make([]*RecordError, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		queue := tx.Bucket([]byte("webhookqueue"))
+		if queue == nil {
+			return nil
+		}
+
+		c := queue.Cursor()
+		for id, userName := c.First(); id != nil && len(due) < limit; id, userName = c.Next() {
+			location := "user." + string(userName) + ".delivery"
+			d := Delivery{}
+			err := rod.GetJson(tx, location, string(id), &d)
+			if err != nil {
+				bad = append(bad, newRecordError(location, string(id), err))
+				continue
+			}
+			if d.Id == "" {
+				bad = append(bad, newRecordError(location, string(id), ErrMissingMeta))
+				continue
+			}
+			if d.NextAttempt.After(now) {
+				continue
+			}
+			due = append(due, &d)
+		}
+
+		return nil
+	})
+
+	return due, bad, err
+}
+
+// SelDeliveries returns this user's delivery log, newest first.
+func SelDeliveries(db *bolt.DB, userName string) ([]*Delivery, []*RecordError, error) {
+	deliveries := make([]*Delivery, 0)
+	bad := make([]*RecordError, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		location := "user." + userName + ".delivery"
+		b, err := rod.GetBucket(tx, location)
+		if err != nil || b == nil {
+			return err
+		}
+
+		c := b.Cursor()
+		for key, val := c.Last(); key != nil; key, val = c.Prev() {
+			d := Delivery{}
+			err := json.Unmarshal(val, &d)
+			if err != nil {
+				bad = append(bad, newRecordError(location, string(key), err))
+				continue
+			}
+			deliveries = append(deliveries, &d)
+		}
+
+		return nil
+	})
+
+	return deliveries, bad, err
+}
+
+// PutCredential saves the user's access token for this provider, encrypted, replacing any earlier one. It fails with
+// errNoCredentialKey if there's no key to encrypt it with.
+func PutCredential(db *bolt.DB, userName string, c Credential) error {
+	sc, err := sealCredential(userName, c)
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		return rod.PutJson(tx, "user."+userName+".credential", c.Provider, sc)
+	})
+}
+
+// GetCredential returns the user's access token for this provider, or an empty one if we don't have one.
+func GetCredential(db *bolt.DB, userName, provider string) (Credential, error) {
+	sc := sealedCredential{}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		return rod.GetJson(tx, "user."+userName+".credential", provider, &sc)
+	})
+	if err != nil || len(sc.Sealed) == 0 {
+		return Credential{}, err
+	}
+
+	return openCredential(userName, sc)
+}
+
+// DelCredentials removes all of the user's access tokens, once they're no longer cross-posting.
+func DelCredentials(db *bolt.DB, userName string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := rod.GetBucket(tx, "user."+userName)
+		if err != nil || b == nil || b.Bucket([]byte("credential")) == nil {
+			return err
+		}
+		return b.DeleteBucket([]byte("credential"))
+	})
+}
+
+// PurgePlainCredentials removes any credential which was kept before they were encrypted, returning how many there
+// were.
+func PurgePlainCredentials(db *bolt.DB) (int, error) {
+	n := 0
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte("user"))
+		if users == nil {
+			return nil
+		}
+
+		return users.ForEach(func(userName, v []byte) error {
+			ub := users.Bucket(userName)
+			if ub == nil {
+				return nil
+			}
+			b := ub.Bucket([]byte("credential"))
+			if b == nil {
+				return nil
+			}
+
+			plain := make([][]byte, 0)
+			b.ForEach(func(provider, raw []byte) error {
+				sc := sealedCredential{}
+				if json.Unmarshal(raw, &sc) != nil || len(sc.Sealed) == 0 {
+					plain = append(plain, provider)
+				}
+				return nil
+			})
+			for _, provider := range plain {
+				if err := b.Delete(provider); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+	})
+
+	return n, err
+}
+
+// InsSocialPost adds this record of a cross-post to the project's audit.
+func InsSocialPost(db *bolt.DB, p Project, sp SocialPost) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		location := "user." + p.UserName + ".project." + p.Name + ".socialpost"
+		return rod.PutJson(tx, location, sp.Id, sp)
+	})
+}
+
+// SelSocialPosts returns the audit of everything cross-posted from this project, newest first.
+func SelSocialPosts(db *bolt.DB, userName, projectName string) ([]*SocialPost, []*RecordError, error) {
+	posts := make([]*SocialPost, 0)
+	bad := make([]*RecordError, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		location := "user." + userName + ".project." + projectName + ".socialpost"
+		b, err := rod.GetBucket(tx, location)
+		if err != nil || b == nil {
+			return err
+		}
+
+		c := b.Cursor()
+		for key, val := c.Last(); key != nil; key, val = c.Prev() {
+			sp := SocialPost{}
+			err := json.Unmarshal(val, &sp)
+			if err != nil {
+				bad = append(bad, newRecordError(location, string(key), err))
+				continue
+			}
+			posts = append(posts, &sp)
+		}
+
+		return nil
+	})
+
+	return posts, bad, err
+}
+
+// Counts are some totals across the whole store, used for metrics.
+type Counts struct {
+	Users          int
+	Projects       int
+	ActiveProjects int // projects created, or with an update, in the last 7 days
+	UpdatesToday   int // updates in the last 24 hours
+}
+
+// GetCounts walks every user and project to total things up. Update keys are formatted times, so we can seek straight
+// to the recent ones rather than reading them all.
+func GetCounts(db *bolt.DB, now time.Time) (Counts, error) {
+	counts := Counts{}
+	weekAgo := []byte(now.Add(-7 * 24 * time.Hour).Format(format))
+	dayAgo := []byte(now.Add(-24 * time.Hour).Format(format))
+
+	err := db.View(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte("user"))
+		if users == nil {
+			return nil
+		}
+
+		return users.ForEach(func(userName, v []byte) error {
+			ub := users.Bucket(userName)
+			if ub == nil {
+				return nil
+			}
+			counts.Users++
+
+			pb := ub.Bucket([]byte("project"))
+			if pb == nil {
+				return nil
+			}
+
+			return pb.ForEach(func(projectName, v []byte) error {
+				b := pb.Bucket(projectName)
+				if b == nil {
+					return nil
+				}
+				counts.Projects++
+
+				active := false
+				p := Project{}
+				if err := json.Unmarshal(b.Get([]byte("meta")), &p); err == nil {
+					active = p.Inserted.Format(format) >= string(weekAgo)
+				}
+
+				if updates := b.Bucket([]byte("update")); updates != nil {
+					c := updates.Cursor()
+					if k, _ := c.Seek(weekAgo); k != nil {
+						active = true
+					}
+					for k, _ := c.Seek(dayAgo); k != nil; k, _ = c.Next() {
+						counts.UpdatesToday++
+					}
+				}
+
+				if active {
+					counts.ActiveProjects++
+				}
+				return nil
+			})
+		})
+	})
+
+	return counts, err
 }
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// testDB opens a new, empty store which is closed and removed after the test.
+func testDB(t *testing.T) *bolt.DB {
+	t.Helper()
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// testProject puts a new project with this title into the store, as /p/new would.
+func testProject(t *testing.T, db *bolt.DB, userName, title, visibility string) *Project {
+	t.Helper()
+
+	p := &Project{Title: title, UserName: userName, Visibility: visibility}
+	if !p.Validate() {
+		t.Fatalf("project %q isn't valid: %v", title, p.Error)
+	}
+	if err := InsNewProject(db, p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// putRaw puts `raw` into the store as it is, creating the buckets for `location` as needed, so tests can corrupt a
+// record.
+func putRaw(t *testing.T, db *bolt.DB, location, key, raw string) {
+	t.Helper()
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		names := strings.Split(location, ".")
+		b, err := tx.CreateBucketIfNotExists([]byte(names[0]))
+		for _, name := range names[1:] {
+			if err != nil {
+				return err
+			}
+			b, err = b.CreateBucketIfNotExists([]byte(name))
+		}
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), []byte(raw))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelProjectsSkipsBadRecords(t *testing.T) {
+	db := testDB(t)
+	testProject(t, db, "bob", "A Good", VisibilityPublic)
+	putRaw(t, db, "user.bob.project.b-bad", "meta", "{not json")
+	testProject(t, db, "bob", "C Good", VisibilityPublic)
+
+	projects, bad, err := SelProjects(db, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 2 || projects[0].Name != "a-good" || projects[1].Name != "c-good" {
+		t.Errorf("projects = %+v, want a-good and c-good", projects)
+	}
+	if len(bad) != 1 || bad[0].Location != "user.bob.project.b-bad" || bad[0].Key != "meta" {
+		t.Errorf("bad = %v, want the meta of b-bad", bad)
+	}
+}
+
+func TestSelUpdatesSkipsBadRecords(t *testing.T) {
+	db := testDB(t)
+	p := testProject(t, db, "bob", "Learn Go", VisibilityPublic)
+
+	for _, status := range []string{"First", "Third"} {
+		now := time.Now().UTC()
+		u := Update{Id: now.Format(idFormat), Status: status, Inserted: now, Updated: now}
+		if err := InsUpdate(db, *p, u); err != nil {
+			t.Fatal(err)
+		}
+		if status == "First" {
+			putRaw(t, db, "user.bob.project.learn-go.update", time.Now().UTC().Format(idFormat), `{"Status": 5}`)
+		}
+	}
+
+	updates, bad, err := SelUpdates(db, "bob", p.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 2 || updates[0].Status != "First" || updates[1].Status != "Third" {
+		t.Errorf("updates = %+v, want First and Third", updates)
+	}
+	if len(bad) != 1 || bad[0].Location != "user.bob.project.learn-go.update" {
+		t.Errorf("bad = %v, want the second update", bad)
+	}
+}
+
+func TestInsGoalDelGoal(t *testing.T) {
+	db := testDB(t)
+	p := testProject(t, db, "bob", "Learn Go", VisibilityPublic)
+
+	g, ok := p.NewGoal("Read the tour", 1)
+	if !ok {
+		t.Fatal(p.Error)
+	}
+	if err := InsGoal(db, *p, g); err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := GetProject(db, "bob", p.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added.Goals) != 1 || added.Goals[0].Title != "Read the tour" {
+		t.Errorf("goals = %+v", added.Goals)
+	}
+	if !added.Updated.After(p.Updated) {
+		t.Errorf("adding a goal didn't move Updated on")
+	}
+
+	if err := DelGoal(db, added, g.Id); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := GetProject(db, "bob", p.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed.Goals) != 0 {
+		t.Errorf("goals = %+v", removed.Goals)
+	}
+	if !removed.Updated.After(added.Updated) {
+		t.Errorf("removing a goal didn't move Updated on")
+	}
+
+	// the project is still indexed as it was
+	results, err := SelSearch(db, "learn", nil, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Errorf("search found %d results, want 1", len(results))
+	}
+
+	if err := InsGoal(db, Project{UserName: "bob", Name: "missing"}, g); err != ErrMissingMeta {
+		t.Errorf("adding a goal to a missing project: err = %v", err)
+	}
+}
+
+func TestInsUpdate(t *testing.T) {
+	db := testDB(t)
+	p := testProject(t, db, "bob", "Learn Go", VisibilityPublic)
+	for i, title := range []string{"Read the tour", "Write a CLI"} {
+		g, _ := p.NewGoal(title, i+1)
+		if err := InsGoal(db, *p, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen, err := GetProject(db, "bob", p.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// alice adds a goal and ticks off the second while bob's update form only showed the first
+	extra, _ := seen.NewGoal("Write a server", 3)
+	if err := InsGoal(db, seen, extra); err != nil {
+		t.Fatal(err)
+	}
+	ticked, _ := GetProject(db, "bob", p.Name)
+	ticked.Goals[1].Done = true
+	if err := InsProject(db, ticked); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	u := Update{Id: now.Format(idFormat), Status: "Read it", Progress: 40, Goals: []string{seen.Goals[0].Id}, Inserted: now, Updated: now}
+	u.Done = seen.CheckGoals(u.Goals, []string{seen.Goals[0].Id}, "bob", now)
+	if err := InsUpdate(db, seen, u); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := GetProject(db, "bob", p.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Progress != 40 || !got.Updated.Equal(now) {
+		t.Errorf("progress = %d and updated = %v, want 40 and %v", got.Progress, got.Updated, now)
+	}
+	if len(got.Goals) != 3 || !got.Goals[0].Done || !got.Goals[1].Done || got.Goals[2].Done {
+		t.Errorf("goals = %+v, want the first two done and alice's new one kept", got.Goals)
+	}
+
+	updates, _, err := SelUpdates(db, "bob", p.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 1 || updates[0].Status != "Read it" {
+		t.Errorf("updates = %+v", updates)
+	}
+
+	// nothing is stored for a project which doesn't exist
+	if err := InsUpdate(db, Project{UserName: "bob", Name: "missing"}, u); err != ErrMissingMeta {
+		t.Errorf("updating a missing project: err = %v", err)
+	}
+	if updates, _, _ := SelUpdates(db, "bob", "missing"); len(updates) != 0 {
+		t.Errorf("updates on a missing project = %+v", updates)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	db := testDB(t)
+
+	for _, pair := range [][2]string{{"alice", "chilts"}, {"bob", "chilts"}, {"chilts", "alice"}} {
+		if err := InsFollow(db, pair[0], pair[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// following twice is the same as once
+	if err := InsFollow(db, "alice", "chilts"); err != nil {
+		t.Fatal(err)
+	}
+
+	if following, err := IsFollowing(db, "alice", "chilts"); err != nil || !following {
+		t.Errorf("alice follows chilts = %t, %v", following, err)
+	}
+	if following, err := IsFollowing(db, "chilts", "bob"); err != nil || following {
+		t.Errorf("chilts follows bob = %t, %v", following, err)
+	}
+	if followers, following, err := GetFollowCounts(db, "chilts"); err != nil || followers != 2 || following != 1 {
+		t.Errorf("chilts has %d followers and follows %d, want 2 and 1 (%v)", followers, following, err)
+	}
+
+	if err := DelFollow(db, "alice", "chilts"); err != nil {
+		t.Fatal(err)
+	}
+	if following, _ := IsFollowing(db, "alice", "chilts"); following {
+		t.Error("alice still follows chilts")
+	}
+	if followers, _, _ := GetFollowCounts(db, "chilts"); followers != 1 {
+		t.Errorf("chilts has %d followers, want 1", followers)
+	}
+	if names, err := SelFollowing(db, "alice"); err != nil || len(names) != 0 {
+		t.Errorf("alice follows %v, want nobody", names)
+	}
+	if names, err := SelFollowing(db, "bob"); err != nil || len(names) != 1 || names[0] != "chilts" {
+		t.Errorf("bob follows %v, want chilts", names)
+	}
+
+	// unfollowing someone you don't follow is fine
+	if err := DelFollow(db, "dave", "chilts"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSelFeed(t *testing.T) {
+	db := testDB(t)
+	since := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	post := func(p *Project, status string, at time.Time) {
+		t.Helper()
+		u := Update{Id: at.Format(idFormat), Status: status, Inserted: at, Updated: at}
+		if err := InsUpdate(db, *p, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chilts := testProject(t, db, "chilts", "Learn Go", VisibilityPublic)
+	alice := testProject(t, db, "alice", "Learn Rust", VisibilityPublic)
+	bob := testProject(t, db, "bob", "Learn Zig", VisibilityPublic)
+
+	post(chilts, "too early", since.Add(-100*time.Millisecond))
+	post(chilts, "same second", since.Add(500*time.Millisecond))
+	post(alice, "a minute in", since.Add(time.Minute))
+	post(chilts, "an hour in", since.Add(time.Hour))
+	post(bob, "not followed", since.Add(2*time.Hour))
+
+	statuses := func(items []*FeedItem) string {
+		out := make([]string, 0, len(items))
+		for _, item := range items {
+			out = append(out, item.Update.Status)
+		}
+		return strings.Join(out, ",")
+	}
+
+	items, _, err := SelFeed(db, []string{"chilts", "alice"}, since, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := statuses(items), "an hour in,a minute in,same second"; got != want {
+		t.Errorf("feed = %q, want %q", got, want)
+	}
+
+	items, _, err = SelFeed(db, []string{"chilts", "alice"}, since, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := statuses(items), "an hour in,a minute in"; got != want {
+		t.Errorf("feed limited to 2 = %q, want %q", got, want)
+	}
+}
+
+func TestToggleKudos(t *testing.T) {
+	db := testDB(t)
+	p := testProject(t, db, "chilts", "Learn Go", VisibilityPublic)
+	now := time.Now().UTC()
+	u := Update{Id: now.Format(idFormat), Status: "Read the tour", Inserted: now, Updated: now}
+	if err := InsUpdate(db, *p, u); err != nil {
+		t.Fatal(err)
+	}
+
+	// the count on the update, checked against the per-user records
+	count := func() int {
+		t.Helper()
+		updates, _, err := SelUpdates(db, "chilts", p.Name)
+		if err != nil || len(updates) != 1 {
+			t.Fatalf("updates = %v, %v", updates, err)
+		}
+		records := 0
+		for _, name := range []string{"alice", "al", "bob"} {
+			given, err := SelKudosBy(db, *p, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records += len(given)
+		}
+		if updates[0].Kudos != records {
+			t.Errorf("the update's count is %d, but there are %d records", updates[0].Kudos, records)
+		}
+		return updates[0].Kudos
+	}
+
+	tests := []struct {
+		userName string
+		given    bool
+		count    int
+	}{
+		{"alice", true, 1},
+		{"bob", true, 2},
+		{"alice", false, 1}, // a second time takes it away again
+		{"alice", true, 2},
+		{"alice", false, 1},
+		{"bob", false, 0},
+	}
+	for i, tt := range tests {
+		given, err := ToggleKudos(db, *p, u.Id, tt.userName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if given != tt.given || count() != tt.count {
+			t.Errorf("%d: %s toggled to %t with a count of %d, want %t and %d", i, tt.userName, given, count(), tt.given, tt.count)
+		}
+	}
+
+	// one user's kudos aren't mistaken for another's whose name starts the same
+	if _, err := ToggleKudos(db, *p, u.Id, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if given, _ := SelKudosBy(db, *p, "al"); len(given) != 0 {
+		t.Errorf("al has given kudos to %v", given)
+	}
+	if given, _ := SelKudosBy(db, *p, "alice"); !given[u.Id] {
+		t.Errorf("alice has given kudos to %v, want %s", given, u.Id)
+	}
+
+	if _, err := ToggleKudos(db, *p, "missing", "alice"); err != ErrNoSuchUpdate {
+		t.Errorf("kudos on a missing update: err = %v", err)
+	}
+}
+
+func TestInsNewProjectNames(t *testing.T) {
+	db := testDB(t)
+
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Learn Go", "learn-go"},
+		{"Learn Go", "learn-go-2"},
+		{"Learn  Go!", "learn-go-3"},
+		{"New", "new-2"}, // `/p/new` is taken by the page for a new project
+		{"New", "new-3"},
+		{"Edit", "edit-2"},
+		{"P", "p-2"},
+	}
+	for _, tt := range tests {
+		if p := testProject(t, db, "chilts", tt.title, VisibilityPublic); p.Name != tt.want {
+			t.Errorf("%q was put under %q, want %q", tt.title, p.Name, tt.want)
+		}
+	}
+
+	// another user can have the same names
+	if p := testProject(t, db, "alice", "Learn Go", VisibilityPublic); p.Name != "learn-go" {
+		t.Errorf("alice's project was put under %q, want learn-go", p.Name)
+	}
+
+	// after "-1000" there's nowhere left to go
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket([]byte("user")).Bucket([]byte("chilts")).CreateBucketIfNotExists([]byte("project"))
+		if err != nil {
+			return err
+		}
+		for i := 1; i <= 1000; i++ {
+			name := "busy"
+			if i > 1 {
+				name = fmt.Sprintf("busy-%d", i)
+			}
+			if _, err := b.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Project{Title: "Busy", UserName: "chilts", Visibility: VisibilityPublic}
+	p.Validate()
+	if err := InsNewProject(db, p); err == nil {
+		t.Errorf("got %q, want an error once every suffix is taken", p.Name)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/chilts/rod"
+)
+
+// tagCloudPeriod is how far back the tag cloud looks, and tagCloudLimit how many tags it shows.
+var tagCloudPeriod = 30 * 24 * time.Hour
+var tagCloudLimit = 40
+
+// indexTags updates the `tag.*` buckets for a project which is being saved, within the caller's transaction. `old` is
+// the project as it was before, which may be empty.
+func indexTags(tx *bolt.Tx, old, p *Project) error {
+	key := p.UserName + "/" + p.Name
+
+	for _, tag := range old.Tags {
+		if p.HasTag(tag) {
+			continue
+		}
+		b, err := rod.GetBucket(tx, "tag."+tag)
+		if err != nil {
+			return err
+		}
+		if b == nil {
+			continue
+		}
+		err = b.Delete([]byte(key))
+		if err != nil {
+			return err
+		}
+	}
+
+	// the entry is always rewritten, since the visibility may have changed
+	entry := TagEntry{
+		UserName:    p.UserName,
+		ProjectName: p.Name,
+		Public:      p.IsPublic(),
+		Inserted:    p.Inserted,
+	}
+	for _, tag := range p.Tags {
+		err := rod.PutJson(tx, "tag."+tag, key, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SelTagProjects returns the public projects with this tag, newest first.
+func SelTagProjects(db *bolt.DB, tag string) ([]*Project, []*RecordError, error) {
+	projects := make([]*Project, 0)
+	bad := make([]*RecordError, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		location := "tag." + tag
+		b, err := rod.GetBucket(tx, location)
+		if err != nil || b == nil {
+			return err
+		}
+
+		return b.ForEach(func(key, val []byte) error {
+			entry := TagEntry{}
+			err := json.Unmarshal(val, &entry)
+			if err != nil {
+				bad = append(bad, newRecordError(location, string(key), err))
+				return nil
+			}
+			if !entry.Public {
+				return nil
+			}
+
+			p := Project{}
+			err = rod.GetJson(tx, "user."+entry.UserName+".project."+entry.ProjectName, "meta", &p)
+			if err != nil {
+				bad = append(bad, newRecordError(location, string(key), err))
+				return nil
+			}
+			if p.Name == "" || !p.IsPublic() {
+				return nil
+			}
+			projects = append(projects, &p)
+			return nil
+		})
+	})
+
+	sort.SliceStable(projects, func(i, j int) bool {
+		return projects[i].Inserted.After(projects[j].Inserted)
+	})
+
+	return projects, bad, err
+}
+
+// countTag returns how many public projects created since `since` have the tag in bucket `b`.
+func countTag(b *bolt.Bucket, since time.Time) int {
+	n := 0
+	b.ForEach(func(key, val []byte) error {
+		entry := TagEntry{}
+		if json.Unmarshal(val, &entry) == nil && entry.Public && !entry.Inserted.Before(since) {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+// SelTagCloud returns the most popular tags on public projects created since `since`, in alphabetical order, each with
+// a Size relative to the most popular.
+func SelTagCloud(db *bolt.DB, since time.Time, limit int) ([]TagCount, error) {
+	counts := make([]TagCount, 0)
+
+	err := db.View(func(tx *bolt.Tx) error {
+		tags := tx.Bucket([]byte("tag"))
+		if tags == nil {
+			return nil
+		}
+
+		return tags.ForEach(func(tag, v []byte) error {
+			b := tags.Bucket(tag)
+			if b == nil {
+				return nil
+			}
+			if n := countTag(b, since); n > 0 {
+				counts = append(counts, TagCount{Tag: string(tag), Count: n})
+			}
+			return nil
+		})
+	})
+
+	// keep the most popular, then show them alphabetically
+	sort.SliceStable(counts, func(i, j int) bool {
+		return counts[i].Count > counts[j].Count
+	})
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Tag < counts[j].Tag
+	})
+
+	max := 0
+	for _, c := range counts {
+		if c.Count > max {
+			max = c.Count
+		}
+	}
+	for i := range counts {
+		counts[i].Size = 1 + (counts[i].Count*4)/max
+	}
+
+	return counts, err
+}
+
+// SelTagsByPrefix returns up to `limit` tags starting with `prefix` which are on at least one public project, for
+// autocomplete. The most used come first.
+func SelTagsByPrefix(db *bolt.DB, prefix string, limit int) ([]TagCount, error) {
+	counts := make([]TagCount, 0)
+	if prefix == "" {
+		return counts, nil
+	}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		tags := tx.Bucket([]byte("tag"))
+		if tags == nil {
+			return nil
+		}
+
+		c := tags.Cursor()
+		for tag, _ := c.Seek([]byte(prefix)); tag != nil && strings.HasPrefix(string(tag), prefix); tag, _ = c.Next() {
+			b := tags.Bucket(tag)
+			if b == nil {
+				continue
+			}
+			if n := countTag(b, time.Time{}); n > 0 {
+				counts = append(counts, TagCount{Tag: string(tag), Count: n})
+			}
+		}
+
+		return nil
+	})
+
+	sort.SliceStable(counts, func(i, j int) bool {
+		return counts[i].Count > counts[j].Count
+	})
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+
+	return counts, err
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// taggedProject puts a new project with these tags (comma separated, as from the form) into the store.
+func taggedProject(t *testing.T, db *bolt.DB, userName, title, visibility, tags string) *Project {
+	t.Helper()
+
+	p := &Project{Title: title, UserName: userName, Visibility: visibility, Tags: []string{tags}}
+	if !p.Validate() {
+		t.Fatalf("project %q isn't valid: %v", title, p.Error)
+	}
+	if err := InsNewProject(db, p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// tagPage is the names of the projects on this tag's page, in order.
+func tagPage(t *testing.T, db *bolt.DB, tag string) string {
+	t.Helper()
+
+	projects, _, err := SelTagProjects(db, tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(projects))
+	for _, p := range projects {
+		names = append(names, p.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestIndexTagsOnEdit(t *testing.T) {
+	db := testDB(t)
+	p := taggedProject(t, db, "chilts", "Learn Go", VisibilityPublic, "Go, Web")
+
+	if got := tagPage(t, db, "web"); got != "learn-go" {
+		t.Errorf("web = %q, want learn-go", got)
+	}
+
+	// swap web for cli
+	edit := func(visibility, tags string) {
+		t.Helper()
+		p.Visibility = visibility
+		p.Tags = []string{tags}
+		if !p.ValidateEdit() {
+			t.Fatal(p.Error)
+		}
+		if err := InsProject(db, *p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	edit(VisibilityPublic, "go, cli")
+
+	for tag, want := range map[string]string{"go": "learn-go", "web": "", "cli": "learn-go"} {
+		if got := tagPage(t, db, tag); got != want {
+			t.Errorf("%s = %q, want %q", tag, got, want)
+		}
+	}
+	if tags, _ := SelTagsByPrefix(db, "web", 10); len(tags) != 0 {
+		t.Errorf("web is still suggested: %+v", tags)
+	}
+
+	// made private, it leaves the tag pages, and comes back when it's public again
+	edit(VisibilityPrivate, "go, cli")
+	if got := tagPage(t, db, "go"); got != "" {
+		t.Errorf("go = %q while private, want nothing", got)
+	}
+	edit(VisibilityPublic, "go, cli")
+	if got := tagPage(t, db, "go"); got != "learn-go" {
+		t.Errorf("go = %q once public again, want learn-go", got)
+	}
+}
+
+func TestSelTagCloud(t *testing.T) {
+	db := testDB(t)
+	now := time.Now().UTC()
+
+	taggedProject(t, db, "chilts", "One", VisibilityPublic, "go, web, cli")
+	taggedProject(t, db, "chilts", "Two", VisibilityPublic, "go, web")
+	taggedProject(t, db, "alice", "Three", VisibilityPublic, "go")
+	taggedProject(t, db, "alice", "Hidden", VisibilityPrivate, "go, secret")
+
+	// one from before the cloud's period
+	old := &Project{Title: "Old", UserName: "bob", Visibility: VisibilityPublic, Tags: []string{"go, rust"}}
+	old.Validate()
+	old.Inserted = now.Add(-2 * tagCloudPeriod)
+	if err := InsNewProject(db, old); err != nil {
+		t.Fatal(err)
+	}
+
+	cloud, err := SelTagCloud(db, now.Add(-tagCloudPeriod), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TagCount{{"cli", 1, 2}, {"go", 3, 5}, {"web", 2, 3}}
+	if len(cloud) != len(want) {
+		t.Fatalf("cloud = %+v, want %+v", cloud, want)
+	}
+	for i := range want {
+		if cloud[i] != want[i] {
+			t.Errorf("cloud[%d] = %+v, want %+v", i, cloud[i], want[i])
+		}
+	}
+
+	// the most popular are kept, still in alphabetical order
+	cloud, err = SelTagCloud(db, now.Add(-tagCloudPeriod), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cloud) != 2 || cloud[0].Tag != "go" || cloud[1].Tag != "web" {
+		t.Errorf("cloud limited to 2 = %+v, want go and web", cloud)
+	}
+}
+
+func TestSelTagsByPrefix(t *testing.T) {
+	db := testDB(t)
+	taggedProject(t, db, "chilts", "One", VisibilityPublic, "go, golang, gopher")
+	taggedProject(t, db, "chilts", "Two", VisibilityPublic, "golang, web")
+	taggedProject(t, db, "chilts", "Three", VisibilityPrivate, "gotcha")
+
+	tests := []struct {
+		prefix string
+		limit  int
+		want   string
+	}{
+		{"go", 10, "golang,go,gopher"}, // the most used first, then in order
+		{"go", 1, "golang"},
+		{"gop", 10, "gopher"},
+		{"got", 10, ""}, // only on a private project
+		{"x", 10, ""},
+		{"", 10, ""},
+	}
+
+	for _, tt := range tests {
+		tags, err := SelTagsByPrefix(db, tt.prefix, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(tags))
+		for _, tc := range tags {
+			names = append(names, tc.Tag)
+		}
+		if got := strings.Join(names, ","); got != tt.want {
+			t.Errorf("SelTagsByPrefix(%q, %d) = %q, want %q", tt.prefix, tt.limit, got, tt.want)
+		}
+	}
+}
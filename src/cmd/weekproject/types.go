@@ -1,6 +1,9 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/mail"
 	"strings"
 	"time"
 
@@ -9,6 +12,17 @@ import (
 
 const format = "2006-01-02T15:04:05Z"
 
+// idFormat is used for the ids of updates, which are also their keys in the store. It has fixed width nanoseconds so
+// that ids still sort by time, and so that two collaborators posting in the same second don't overwrite each other.
+const idFormat = "2006-01-02T15:04:05.000000000Z"
+
+// Who can see a project. Projects from before this was added have an empty Visibility and are public.
+const (
+	VisibilityPublic   = "public"   // anyone, and it shows up in listings
+	VisibilityUnlisted = "unlisted" // anyone with the share link, but it isn't listed anywhere
+	VisibilityPrivate  = "private"  // only the owner and collaborators
+)
+
 type Social struct {
 	Id       string // e.g. "twitter-123456"
 	Name     string // e.g. "chilts" - the nickname they have in this system
@@ -16,6 +30,15 @@ type Social struct {
 	Updated  time.Time
 }
 
+// Credential is a user's access token for a social provider, so that we can post on their behalf. It's kept in
+// `user.<name>.credential` under the provider's name, encrypted, but only while they're cross-posting.
+type Credential struct {
+	Provider string // e.g. "twitter"
+	Token    string
+	Secret   string
+	Updated  time.Time
+}
+
 type User struct {
 	Name     string // e.g. "chilts" (ie. their Twitter handle)
 	Title    string // e.g. "Andrew Chilton"
@@ -25,32 +48,251 @@ type User struct {
 }
 
 type Project struct {
-	Name     string            `schema:"-"`     // e.g. "week-project"
-	Title    string            `schema:"Title"` // e.g. "The Week Project"
-	Content  string            `schema:"Content"`
-	UserName string            `schema:"-"` // e.g. "chilts" // ToDo: decide if we actually need this
-	Progress int               `schema:"-"`
-	Inserted time.Time         `schema:"-"`
-	Updated  time.Time         `schema:"-"`
-	Error    map[string]string `json:"-"`
+	Name             string            `schema:"-"`     // e.g. "week-project"
+	Title            string            `schema:"Title"` // e.g. "The Week Project"
+	Content          string            `schema:"Content"`
+	UserName         string            `schema:"-"`          // e.g. "chilts" // ToDo: decide if we actually need this
+	Members          []string          `schema:"-"`          // collaborators, who may also post updates
+	Visibility       string            `schema:"Visibility"` // one of the Visibility* consts, empty is public
+	ShareToken       string            `schema:"-"`          // for unlisted projects, needed to view them
+	CommentsDisabled bool              `schema:"CommentsDisabled"`
+	CrossPost        bool              `schema:"CrossPost"`  // post the owner's updates to their social account
+	Tags             []string          `schema:"Tags"`       // slugified, e.g. "machine-learning"
+	ClonedFrom       string            `schema:"ClonedFrom"` // "<userName>/<projectName>" of the project this was cloned from
+	Goals            []Goal            `schema:"-"`
+	AutoProgress     bool              `schema:"AutoProgress"` // work out Progress from the goals, rather than the slider
+	Progress         int               `schema:"-"`
+	Inserted         time.Time         `schema:"-"`
+	Updated          time.Time         `schema:"-"`
+	Error            map[string]string `json:"-"`
 }
 
 type Update struct {
-	Id       string            `schema:"Id"`
-	Status   string            `schema:"Status"`
-	Progress int               `schema:"Progress"`
+	Id          string            `schema:"Id"`
+	Author      string            `schema:"-"` // e.g. "chilts", empty for updates from before collaborators
+	Status      string            `schema:"Status"`
+	Progress    int               `schema:"Progress"`
+	Kudos       int               `schema:"-"`              // how many users have given this update kudos
+	Goals       []string          `schema:"Goals" json:"-"` // the ids of the goals which were on the form
+	Done        []string          `schema:"Done"`           // the ids of the goals ticked off, which is only those newly done once saved
+	Attachments []Attachment      `schema:"-"`
+	Inserted    time.Time         `schema:"-"`
+	Updated     time.Time         `schema:"-"`
+	Error       map[string]string `json:"-"`
+	added       []string          // the keys of the blobs Attach added to the store, so Unattach can remove them
+}
+
+// Comment is left by a signed in user on a project, or on one of it's updates if UpdateId is set. They are kept in
+// the project's `comment` bucket.
+type Comment struct {
+	Id       string            `schema:"-"`
+	UpdateId string            `schema:"UpdateId"`
+	Author   string            `schema:"-"`
+	Body     string            `schema:"Body"`
 	Inserted time.Time         `schema:"-"`
-	Updated  time.Time         `schema:"-"`
 	Error    map[string]string `json:"-"`
 }
 
+// Goal is one task in a project's checklist, optionally for a particular day of the project's week.
+type Goal struct {
+	Id     string
+	Title  string
+	Day    int // 1 to 7, or 0 for any day
+	Done   bool
+	DoneBy string
+	DoneAt time.Time
+}
+
+// Attachment is a file attached to an update. The file itself, and any thumbnail, are in the BlobStore.
+type Attachment struct {
+	Key      string // of the blob
+	Name     string // as uploaded, e.g. "screenshot.png"
+	MimeType string // sniffed from the contents, e.g. "image/png"
+	Size     int64
+	Thumb    string // the blob key of a thumbnail, for images
+	Width    int    // of the image, if it is one
+	Height   int
+}
+
+// BlobOwner is a project with an update which has a blob attached, kept in `blob.<key>` under "<userName>/<projectName>"
+// so that we know who can see it.
+type BlobOwner struct {
+	UserName    string
+	ProjectName string
+}
+
+// maxGoals is how many goals a project can have.
+const maxGoals = 50
+
+// Membership records that a user collaborates on someone else's project. It is kept under the collaborator (in
+// `user.<name>.member`) so they can list the projects they're a member of.
+type Membership struct {
+	UserName    string // the owner
+	ProjectName string
+	Inserted    time.Time
+}
+
+// Follow records one user following another. It's kept twice, once under each user: in `user.<follower>.following`
+// with the UserName of who they follow, and in `user.<followee>.follower` with the UserName of the follower.
+type Follow struct {
+	UserName string
+	Inserted time.Time
+}
+
+// FeedItem is an update on a project, as shown on someone's dashboard.
+type FeedItem struct {
+	Project *Project
+	Update  *Update
+}
+
+// The events a user can be emailed about.
+const (
+	EventNewComment  = "new-comment"  // someone commented on one of your projects
+	EventNewFollower = "new-follower" // someone followed you
+	EventWeekEnding  = "week-ending"  // your project's week ends tomorrow
+	EventNoUpdate    = "no-update"    // you haven't posted an update for a while
+)
+
+// The channels a nudge can be sent through.
+const (
+	NudgeEmail   = "email"
+	NudgeWebhook = "webhook"
+)
+
+// defaultNudgeAfter is how many hours without an update before we nudge someone, unless they've said otherwise.
+const defaultNudgeAfter = 48
+
+// Prefs are a user's notification preferences. They're kept under the "prefs" key in `user.<name>` rather than in
+// the User itself, since that is rewritten each time they sign in.
+type Prefs struct {
+	Email       string `schema:"Email"` // if empty, we use the one from sign in
+	NewComment  bool   `schema:"NewComment"`
+	NewFollower bool   `schema:"NewFollower"`
+	WeekEnding  bool   `schema:"WeekEnding"`
+	NoUpdate    bool   `schema:"NoUpdate"`
+
+	// nudges, i.e. reminders when a project has gone quiet
+	NudgeAfter   int    `schema:"NudgeAfter"`   // hours without an update, or 0 for the default
+	NudgeChannel string `schema:"NudgeChannel"` // NudgeEmail (if empty) or NudgeWebhook
+	NudgeWebhook string `schema:"NudgeWebhook"` // e.g. "https://chat.example.com/hooks/abc"
+	NudgeSecret  string `schema:"-"`            // for the HMAC-SHA256 signature of each nudge sent to NudgeWebhook
+	TimeZone     string `schema:"TimeZone"`     // e.g. "Pacific/Auckland", or UTC if empty
+	QuietFrom    int    `schema:"QuietFrom"`    // the hour nudges stop, e.g. 22
+	QuietTo      int    `schema:"QuietTo"`      // the hour nudges start again, e.g. 8, or the same as QuietFrom for none
+
+	Updated time.Time         `schema:"-"`
+	Error   map[string]string `json:"-"`
+}
+
+// QueuedMail is a message waiting to be sent, kept in the `mail` bucket so that it survives a restart. Messages which
+// fail too many times are moved to the `mailfailed` bucket.
+type QueuedMail struct {
+	Id          string
+	Message     Message
+	Event       string
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	Inserted    time.Time
+}
+
+// The events a webhook can receive. EventPing is only ever sent by the "send test" button.
+const (
+	EventProjectCreated   = "project-created"
+	EventUpdatePosted     = "update-posted"
+	EventProjectCompleted = "project-completed"
+	EventPing             = "ping"
+)
+
+// WebhookEvents are the events someone can choose to receive on a webhook.
+var WebhookEvents = []string{EventProjectCreated, EventUpdatePosted, EventProjectCompleted}
+
+// Webhook is a URL which receives a signed JSON payload on project events. It's kept in `user.<name>.webhook` and
+// covers all of the user's projects, or just one if ProjectName is set.
+type Webhook struct {
+	Id          string            `schema:"-"`
+	UserName    string            `schema:"-"`
+	ProjectName string            `schema:"ProjectName"` // empty for all projects
+	Url         string            `schema:"Url"`
+	Events      []string          `schema:"Events"`
+	Secret      string            `schema:"-"` // for the HMAC-SHA256 signature of each payload
+	Inserted    time.Time         `schema:"-"`
+	Error       map[string]string `json:"-"`
+}
+
+// Delivery is one attempt (or series of retries) at sending a payload to a webhook. Deliveries are kept in
+// `user.<name>.delivery` as a log, and the ids of those still to be sent are queued in the `webhookqueue` bucket.
+type Delivery struct {
+	Id          string
+	UserName    string
+	WebhookId   string
+	Url         string
+	Event       string
+	Payload     json.RawMessage
+	Status      string // DeliveryPending, DeliveryDelivered or DeliveryFailed
+	Attempts    int
+	NextAttempt time.Time
+	StatusCode  int
+	LastError   string
+	Inserted    time.Time
+	Updated     time.Time
+}
+
+// The states of a delivery.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// SocialPost is an audit record of an update being cross-posted to a social account, whether it worked or not. It's
+// kept in the project's `.socialpost` bucket.
+type SocialPost struct {
+	Id       string
+	UpdateId string
+	UserName string // whose account it was posted to
+	Provider string // e.g. "twitter"
+	Text     string
+	RemoteId string // the id of the post on the provider, if it worked
+	Error    string // why it failed, if it didn't
+	Inserted time.Time
+}
+
+// TagEntry is a project in a tag's list, kept in `tag.<tag>` under "<userName>/<projectName>". It has just enough of
+// the project to count towards the tag cloud without reading every project.
+type TagEntry struct {
+	UserName    string
+	ProjectName string
+	Public      bool
+	Inserted    time.Time // when the project was created
+}
+
+// TagCount is a tag along with how many projects have it, and a Size from 1 to 5 for showing it in a tag cloud.
+type TagCount struct {
+	Tag   string
+	Count int
+	Size  int
+}
+
+// Activity is a project along with when it was last worked on, for deciding who to remind.
+type Activity struct {
+	Project    *Project
+	LastUpdate time.Time // or when the project was created, if there are no updates yet
+}
+
+// Contributor is someone who has worked on a project, along with how many updates they've posted.
+type Contributor struct {
+	Name    string
+	Updates int
+}
+
 // Validate firstly normalises the project, then validates it and returns either true (valid) or false (invalid). It sets any messages onto
 // the Project.Error field.
 func (p *Project) Validate() bool {
 	now := time.Now().UTC()
 
 	// normalise
-	p.Name = slugify.Slugify(p.Title)
+	p.Name = projectSlug(p.Title)
 	p.Title = strings.TrimSpace(p.Title)
 	p.Inserted = now
 	p.Updated = now
@@ -60,32 +302,513 @@ func (p *Project) Validate() bool {
 		p.Error["Name"] = "Name must be provided"
 	}
 
-	if len(p.Title) == 0 {
-		p.Error["Title"] = "Title must be provided"
-	}
+	checkText(p.Error, "Title", "Title", p.Title, 1, maxTitleLen, false)
+	checkText(p.Error, "Content", "Description", p.Content, 0, maxContentLen, true)
 
 	if len(p.UserName) == 0 {
 		p.Error["UserName"] = "UserName must be provided"
 	}
 
+	p.SetVisibility(p.Visibility)
+	p.SetTags(p.Tags)
+
+	return len(p.Error) == 0
+}
+
+// ValidateEdit is like Validate but for the fields which can be changed on the edit page. It leaves the Name alone,
+// since that's where the project lives in the store.
+func (p *Project) ValidateEdit() bool {
+	// normalise
+	p.Title = strings.TrimSpace(p.Title)
+	p.Updated = time.Now().UTC()
+	p.Error = make(map[string]string)
+
+	checkText(p.Error, "Title", "Title", p.Title, 1, maxTitleLen, false)
+	checkText(p.Error, "Content", "Description", p.Content, 0, maxContentLen, true)
+
+	p.SetVisibility(p.Visibility)
+	p.SetTags(p.Tags)
+
 	return len(p.Error) == 0
 }
 
+// CanEdit says whether this user may edit the project itself, which only the owner may do.
+func (p *Project) CanEdit(u *User) bool {
+	return u != nil && p.UserName == u.Name
+}
+
+// CanUpdate says whether this user may post updates to the project, i.e. the owner or any collaborator.
+func (p *Project) CanUpdate(u *User) bool {
+	return u != nil && (p.UserName == u.Name || p.IsMember(u.Name))
+}
+
+// IsMember says whether this userName is a collaborator on the project.
+func (p *Project) IsMember(userName string) bool {
+	for _, name := range p.Members {
+		if name == userName {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPublic says whether this project can be seen by anyone, and so appear in listings, feeds and search.
+func (p *Project) IsPublic() bool {
+	return p.Visibility == "" || p.Visibility == VisibilityPublic
+}
+
+// CanView says whether this user (who may be nil) can see the project, given any share token from the URL.
+func (p *Project) CanView(u *User, shareToken string) bool {
+	if p.IsPublic() || p.CanUpdate(u) {
+		return true
+	}
+	if p.Visibility == VisibilityUnlisted {
+		return p.ShareToken != "" && subtle.ConstantTimeCompare([]byte(shareToken), []byte(p.ShareToken)) == 1
+	}
+	return false
+}
+
+// ShareUrl is the URL to give out for this project, which includes the share token if the project is unlisted.
+func (p *Project) ShareUrl() string {
+	if p.Visibility == VisibilityUnlisted {
+		return p.Url() + "?share=" + p.ShareToken
+	}
+	return p.Url()
+}
+
+// SetVisibility validates and sets the visibility, making sure unlisted projects have a share token. It sets any
+// message onto the Project.Error field.
+func (p *Project) SetVisibility(visibility string) bool {
+	switch visibility {
+	case "":
+		visibility = VisibilityPublic
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		// all good
+	default:
+		p.Error["Visibility"] = "Visibility must be public, unlisted or private"
+		return false
+	}
+
+	p.Visibility = visibility
+	if p.Visibility == VisibilityUnlisted && p.ShareToken == "" {
+		p.ShareToken = randomHex(16)
+	}
+	return true
+}
+
+// Url returns the public URL of this project.
+func (p *Project) Url() string {
+	return "/u/" + p.UserName + "/p/" + p.Name + "/"
+}
+
+// Contributors returns the owner followed by each collaborator, with a count of the updates each has posted. Updates
+// with no Author were all posted by the owner.
+func (p *Project) Contributors(updates []*Update) []Contributor {
+	counts := make(map[string]int)
+	for _, u := range updates {
+		author := u.Author
+		if author == "" {
+			author = p.UserName
+		}
+		counts[author]++
+	}
+
+	contributors := []Contributor{{p.UserName, counts[p.UserName]}}
+	for _, name := range p.Members {
+		contributors = append(contributors, Contributor{name, counts[name]})
+	}
+	return contributors
+}
+
+// Key is the key of this membership within the collaborator's `member` bucket.
+func (m Membership) Key() string {
+	return m.UserName + "/" + m.ProjectName
+}
+
 func (u *Update) Validate() bool {
 	// normalise
 	now := time.Now().UTC()
-	u.Id = now.Format(format)
+	u.Id = now.Format(idFormat)
 	u.Inserted = now
 	u.Updated = now
 	u.Error = make(map[string]string)
 
-	if len(u.Status) > 1000 {
-		u.Error["Status"] = "Status should be less than 1,000 chars"
+	checkText(u.Error, "Status", "Status", u.Status, 0, maxStatusLen, true)
+	checkRange(u.Error, "Progress", "Progress", u.Progress, 0, 100)
+
+	return len(u.Error) == 0
+}
+
+// Validate normalises and validates the comment, setting any messages onto the Comment.Error field.
+func (c *Comment) Validate() bool {
+	now := time.Now().UTC()
+
+	// normalise
+	c.Id = now.Format(idFormat)
+	c.Body = strings.TrimSpace(c.Body)
+	c.Inserted = now
+	c.Error = make(map[string]string)
+
+	checkText(c.Error, "Body", "Comment", c.Body, 1, maxCommentLen, true)
+
+	if len(c.Author) == 0 {
+		c.Error["Author"] = "Author must be provided"
+	}
+
+	return len(c.Error) == 0
+}
+
+// CanDelete says whether this user may delete the comment, i.e. the project owner (who moderates) or whoever wrote it.
+func (c *Comment) CanDelete(p *Project, u *User) bool {
+	return u != nil && (p.CanEdit(u) || c.Author == u.Name)
+}
+
+// Validate normalises and validates a new webhook, setting any messages onto the Webhook.Error field.
+func (wh *Webhook) Validate() bool {
+	now := time.Now().UTC()
+
+	// normalise
+	wh.Id = now.Format(idFormat)
+	wh.Url = strings.TrimSpace(wh.Url)
+	wh.Secret = randomHex(32)
+	wh.Inserted = now
+	wh.Error = make(map[string]string)
+
+	if msg := checkOutgoingUrl(wh.Url); msg != "" {
+		wh.Error["Url"] = msg
 	}
 
-	if u.Progress < 0 && u.Progress > 100 {
-		u.Error["Progress"] = "Progress should be between 0 and 100 inclusive"
+	if len(wh.Events) == 0 {
+		wh.Error["Events"] = "Choose at least one event"
+	}
+	for _, event := range wh.Events {
+		if !validWebhookEvent(event) {
+			wh.Error["Events"] = "Unknown event " + event
+		}
 	}
 
-	return len(u.Error) == 0
+	if len(wh.UserName) == 0 {
+		wh.Error["UserName"] = "UserName must be provided"
+	}
+
+	return len(wh.Error) == 0
+}
+
+func validWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Wants says whether this webhook should receive `event` for project `p`. Every webhook gets a ping.
+func (wh *Webhook) Wants(p *Project, event string) bool {
+	if wh.ProjectName != "" && wh.ProjectName != p.Name {
+		return false
+	}
+	return event == EventPing || wh.HasEvent(event)
+}
+
+// HasEvent is for templates, to say whether this webhook receives `event`.
+func (wh *Webhook) HasEvent(event string) bool {
+	for _, e := range wh.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultPrefs are the preferences for anyone who hasn't changed them, which is to be told about everything.
+func DefaultPrefs() Prefs {
+	return Prefs{
+		NewComment:  true,
+		NewFollower: true,
+		WeekEnding:  true,
+		NoUpdate:    true,
+
+		NudgeAfter:   defaultNudgeAfter,
+		NudgeChannel: NudgeEmail,
+		TimeZone:     "UTC",
+		QuietFrom:    22,
+		QuietTo:      8,
+	}
+}
+
+// Validate normalises and validates the prefs, setting any messages onto the Prefs.Error field.
+func (p *Prefs) Validate() bool {
+	// normalise
+	p.Email = strings.TrimSpace(p.Email)
+	p.Updated = time.Now().UTC()
+	p.Error = make(map[string]string)
+
+	if len(p.Email) > 254 {
+		p.Error["Email"] = "Email should be less than 254 chars"
+	} else if p.Email != "" {
+		if _, err := mail.ParseAddress(p.Email); err != nil {
+			p.Error["Email"] = "Email doesn't look like an email address"
+		}
+	}
+
+	if p.NudgeAfter == 0 {
+		p.NudgeAfter = defaultNudgeAfter
+	}
+	if p.NudgeAfter < 1 || p.NudgeAfter > 7*24 {
+		p.Error["NudgeAfter"] = "Nudge after should be between 1 and 168 hours"
+	}
+
+	switch p.NudgeChannel {
+	case "", NudgeEmail:
+		p.NudgeChannel = NudgeEmail
+	case NudgeWebhook:
+		p.NudgeWebhook = strings.TrimSpace(p.NudgeWebhook)
+		if msg := checkOutgoingUrl(p.NudgeWebhook); msg != "" {
+			p.Error["NudgeWebhook"] = "Webhook " + msg
+		}
+	default:
+		p.Error["NudgeChannel"] = "Nudges can only be sent by email or webhook"
+	}
+
+	if p.TimeZone == "" {
+		p.TimeZone = "UTC"
+	}
+	if _, err := time.LoadLocation(p.TimeZone); err != nil {
+		p.Error["TimeZone"] = "Time zone should be a name such as Europe/London"
+	}
+
+	if p.QuietFrom < 0 || p.QuietFrom > 23 || p.QuietTo < 0 || p.QuietTo > 23 {
+		p.Error["Quiet"] = "Quiet hours should be between 0 and 23"
+	}
+
+	// the secret is made the first time they choose a webhook, and kept after that
+	if len(p.Error) == 0 && p.NudgeChannel == NudgeWebhook && p.NudgeSecret == "" {
+		p.NudgeSecret = randomHex(32)
+	}
+
+	return len(p.Error) == 0
+}
+
+// NudgeAfterPeriod is how long a project can go without an update before we nudge it's owner.
+func (p *Prefs) NudgeAfterPeriod() time.Duration {
+	if p.NudgeAfter <= 0 {
+		return defaultNudgeAfter * time.Hour
+	}
+	return time.Duration(p.NudgeAfter) * time.Hour
+}
+
+// Location returns the user's time zone, or UTC if they haven't set one (or it's no longer valid).
+func (p *Prefs) Location() *time.Location {
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// InQuietHours says whether `now` falls in the user's quiet hours, in their own time zone. The quiet hours may wrap
+// around midnight, e.g. from 22 until 8.
+func (p *Prefs) InQuietHours(now time.Time) bool {
+	if p.QuietFrom == p.QuietTo {
+		return false
+	}
+	hour := now.In(p.Location()).Hour()
+	if p.QuietFrom < p.QuietTo {
+		return hour >= p.QuietFrom && hour < p.QuietTo
+	}
+	return hour >= p.QuietFrom || hour < p.QuietTo
+}
+
+// Wants says whether the user wants to be emailed about this event.
+func (p *Prefs) Wants(event string) bool {
+	switch event {
+	case EventNewComment:
+		return p.NewComment
+	case EventNewFollower:
+		return p.NewFollower
+	case EventWeekEnding:
+		return p.WeekEnding
+	case EventNoUpdate:
+		return p.NoUpdate
+	}
+	return false
+}
+
+// maxTags is how many tags a project can have, and maxTagLen how long each can be.
+const maxTags = 10
+const maxTagLen = 30
+
+// SetTags normalises and sets the tags. Each of `tags` may hold several tags separated by commas, as they come from
+// the form. Tags are slugified the same as project names, and any repeats are dropped. It sets any message onto the
+// Project.Error field.
+func (p *Project) SetTags(tags []string) bool {
+	p.Tags = make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, field := range tags {
+		for _, raw := range strings.Split(field, ",") {
+			tag := slugify.Slugify(strings.TrimSpace(raw))
+			if tag == "" || seen[tag] {
+				continue
+			}
+			if len(tag) > maxTagLen {
+				p.Error["Tags"] = "Each tag should be less than 30 chars"
+				continue
+			}
+			seen[tag] = true
+			p.Tags = append(p.Tags, tag)
+		}
+	}
+
+	if len(p.Tags) > maxTags {
+		p.Error["Tags"] = "A project can have up to 10 tags"
+		p.Tags = p.Tags[:maxTags]
+	}
+
+	return p.Error["Tags"] == ""
+}
+
+// TagList is the tags as they'd be typed into the form, e.g. "go, web".
+func (p *Project) TagList() string {
+	return strings.Join(p.Tags, ", ")
+}
+
+// Clone returns a new, unsaved, project for `userName` with this project's title, content, tags and goals (none of
+// them done), but none of it's updates, members or other settings.
+func (p *Project) Clone(userName string) *Project {
+	goals := make([]Goal, 0, len(p.Goals))
+	for _, g := range p.Goals {
+		goals = append(goals, Goal{Id: g.Id, Title: g.Title, Day: g.Day})
+	}
+
+	return &Project{
+		Title:        p.Title,
+		Content:      p.Content,
+		Tags:         append([]string{}, p.Tags...),
+		UserName:     userName,
+		ClonedFrom:   p.UserName + "/" + p.Name,
+		Goals:        goals,
+		AutoProgress: p.AutoProgress,
+	}
+}
+
+// ClonedFromUrl is the URL of the project this was cloned from, if any.
+func (p *Project) ClonedFromUrl() string {
+	parts := strings.SplitN(p.ClonedFrom, "/", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	return "/u/" + parts[0] + "/p/" + parts[1] + "/"
+}
+
+// NewGoal validates and returns a new goal, setting any message onto the Project.Error field under "Goal".
+func (p *Project) NewGoal(title string, day int) (Goal, bool) {
+	if p.Error == nil {
+		p.Error = make(map[string]string)
+	}
+
+	g := Goal{Id: randomHex(6), Title: strings.TrimSpace(title), Day: day}
+
+	checkRange(p.Error, "Goal", "Day", g.Day, 0, 7)
+	checkText(p.Error, "Goal", "Goal", g.Title, 1, maxGoalLen, false)
+	if len(p.Goals) >= maxGoals {
+		p.Error["Goal"] = "A project can have up to 50 goals"
+	}
+
+	return g, p.Error["Goal"] == ""
+}
+
+// CheckGoals ticks off the goals in `done` and unticks the others, out of those in `shown` (i.e. those which were on
+// the form, so that goals added since aren't touched). It returns the ids of the goals which are newly done.
+func (p *Project) CheckGoals(shown, done []string, by string, now time.Time) []string {
+	isShown := make(map[string]bool)
+	for _, id := range shown {
+		isShown[id] = true
+	}
+	isDone := make(map[string]bool)
+	for _, id := range done {
+		isDone[id] = true
+	}
+
+	newly := make([]string, 0)
+	for i := range p.Goals {
+		g := &p.Goals[i]
+		if !isShown[g.Id] {
+			continue
+		}
+		if isDone[g.Id] && !g.Done {
+			g.Done = true
+			g.DoneBy = by
+			g.DoneAt = now
+			newly = append(newly, g.Id)
+		}
+		if !isDone[g.Id] && g.Done {
+			g.Done = false
+			g.DoneBy = ""
+			g.DoneAt = time.Time{}
+		}
+	}
+
+	return newly
+}
+
+// SetGoalStates copies whether each goal is done from `goals`, for those which still exist.
+func (p *Project) SetGoalStates(goals []Goal) {
+	byId := make(map[string]Goal)
+	for _, g := range goals {
+		byId[g.Id] = g
+	}
+
+	for i := range p.Goals {
+		if g, ok := byId[p.Goals[i].Id]; ok {
+			p.Goals[i].Done = g.Done
+			p.Goals[i].DoneBy = g.DoneBy
+			p.Goals[i].DoneAt = g.DoneAt
+		}
+	}
+}
+
+// GoalProgress is the percentage of goals which are done, or 0 if there are none.
+func (p *Project) GoalProgress() int {
+	if len(p.Goals) == 0 {
+		return 0
+	}
+	done := 0
+	for _, g := range p.Goals {
+		if g.Done {
+			done++
+		}
+	}
+	return done * 100 / len(p.Goals)
+}
+
+// GoalTitle returns the title of the goal with this id, or an empty string if it has since been removed.
+func (p *Project) GoalTitle(id string) string {
+	for _, g := range p.Goals {
+		if g.Id == id {
+			return g.Title
+		}
+	}
+	return ""
+}
+
+// HasTag says whether the project has this tag.
+func (p *Project) HasTag(tag string) bool {
+	for _, t := range p.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Ends is when the project's week is up.
+func (p *Project) Ends() time.Time {
+	return p.Inserted.Add(7 * 24 * time.Hour)
+}
+
+// IsActive says whether the project is still within it's week and not yet finished.
+func (p *Project) IsActive(now time.Time) bool {
+	return p.Progress < 100 && now.Before(p.Ends())
 }
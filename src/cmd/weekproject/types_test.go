@@ -0,0 +1,381 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProjectValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		project Project
+		errors  []string // the fields which should have an error
+		slug    string
+	}{
+		{"ok", Project{Title: "The Week Project", Content: "Learning Go", UserName: "chilts"}, nil, "the-week-project"},
+		{"title is trimmed", Project{Title: "  Learn Go  ", UserName: "chilts"}, nil, "learn-go"},
+		{"no title", Project{Title: "", UserName: "chilts"}, []string{"Title"}, ""},
+		{"blank title", Project{Title: "   ", UserName: "chilts"}, []string{"Title"}, ""},
+		{"title at the limit", Project{Title: strings.Repeat("a", maxTitleLen), UserName: "chilts"}, nil, strings.Repeat("a", maxTitleLen)},
+		{"title too long", Project{Title: strings.Repeat("a", maxTitleLen+1), UserName: "chilts"}, []string{"Title"}, strings.Repeat("a", maxTitleLen+1)},
+		{"title counts chars not bytes", Project{Title: "Go " + strings.Repeat("é", maxTitleLen-3), UserName: "chilts"}, nil, ""},
+		{"title with a control char", Project{Title: "Learn\x00Go", UserName: "chilts"}, []string{"Title"}, ""},
+		{"title with a newline", Project{Title: "Learn\nGo", UserName: "chilts"}, []string{"Title"}, ""},
+		{"content may have newlines", Project{Title: "Learn Go", Content: "Day 1\r\nDay 2\tand 3", UserName: "chilts"}, nil, "learn-go"},
+		{"content with a control char", Project{Title: "Learn Go", Content: "bell\a", UserName: "chilts"}, []string{"Content"}, "learn-go"},
+		{"content too long", Project{Title: "Learn Go", Content: strings.Repeat("a", maxContentLen+1), UserName: "chilts"}, []string{"Content"}, "learn-go"},
+		{"reserved slug is left for the store", Project{Title: "New", UserName: "chilts"}, nil, "new"},
+		{"transliterated title", Project{Title: "Café Crème", UserName: "chilts"}, nil, "cafe-creme"},
+		{"title slugify can't handle", Project{Title: "日本語を 学ぶ", UserName: "chilts"}, nil, "日本語を-学ぶ"},
+		{"title with no letters", Project{Title: "🎉 🎉", UserName: "chilts"}, nil, "project"},
+		{"no user", Project{Title: "Learn Go"}, []string{"UserName"}, "learn-go"},
+		{"bad visibility", Project{Title: "Learn Go", UserName: "chilts", Visibility: "secret"}, []string{"Visibility"}, "learn-go"},
+		{"too many tags", Project{Title: "Learn Go", UserName: "chilts", Tags: []string{"a,b,c,d,e,f,g,h,i,j,k"}}, []string{"Tags"}, "learn-go"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.project
+			ok := p.Validate()
+
+			if ok != (len(tt.errors) == 0) {
+				t.Errorf("Validate() = %v, errors %v", ok, p.Error)
+			}
+			for _, field := range tt.errors {
+				if p.Error[field] == "" {
+					t.Errorf("expected an error for %s, got %v", field, p.Error)
+				}
+			}
+			if len(p.Error) != len(tt.errors) {
+				t.Errorf("expected errors for %v, got %v", tt.errors, p.Error)
+			}
+			if tt.slug != "" && p.Name != tt.slug {
+				t.Errorf("Name = %q, want %q", p.Name, tt.slug)
+			}
+			if p.Inserted.IsZero() {
+				t.Errorf("Inserted wasn't set")
+			}
+		})
+	}
+}
+
+func TestProjectValidateEdit(t *testing.T) {
+	tests := []struct {
+		name    string
+		project Project
+		errors  []string
+	}{
+		{"ok", Project{Name: "learn-go", Title: "Learn Go, Properly"}, nil},
+		{"keeps a reserved name", Project{Name: "new", Title: "Something Else"}, nil},
+		{"no title", Project{Name: "learn-go", Title: " "}, []string{"Title"}},
+		{"title too long", Project{Name: "learn-go", Title: strings.Repeat("a", maxTitleLen+1)}, []string{"Title"}},
+		{"content too long", Project{Name: "learn-go", Title: "Learn Go", Content: strings.Repeat("a", maxContentLen+1)}, []string{"Content"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.project
+			name := p.Name
+			ok := p.ValidateEdit()
+
+			if ok != (len(tt.errors) == 0) {
+				t.Errorf("ValidateEdit() = %v, errors %v", ok, p.Error)
+			}
+			for _, field := range tt.errors {
+				if p.Error[field] == "" {
+					t.Errorf("expected an error for %s, got %v", field, p.Error)
+				}
+			}
+			if p.Name != name {
+				t.Errorf("Name changed from %q to %q", name, p.Name)
+			}
+		})
+	}
+}
+
+func TestUpdateValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		update Update
+		errors []string
+	}{
+		{"ok", Update{Status: "Finished chapter 3", Progress: 40}, nil},
+		{"no status", Update{Progress: 40}, nil},
+		{"status may have newlines", Update{Status: "Done:\n- one\n- two", Progress: 40}, nil},
+		{"status at the limit", Update{Status: strings.Repeat("a", maxStatusLen), Progress: 40}, nil},
+		{"status too long", Update{Status: strings.Repeat("a", maxStatusLen+1), Progress: 40}, []string{"Status"}},
+		{"status with a control char", Update{Status: "esc\x1b[31m", Progress: 40}, []string{"Status"}},
+		{"progress of 0", Update{Progress: 0}, nil},
+		{"progress of 100", Update{Progress: 100}, nil},
+		{"negative progress", Update{Progress: -1}, []string{"Progress"}},
+		{"progress over 100", Update{Progress: 101}, []string{"Progress"}},
+		{"both bad", Update{Status: strings.Repeat("a", maxStatusLen+1), Progress: 1000}, []string{"Status", "Progress"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := tt.update
+			ok := u.Validate()
+
+			if ok != (len(tt.errors) == 0) {
+				t.Errorf("Validate() = %v, errors %v", ok, u.Error)
+			}
+			for _, field := range tt.errors {
+				if u.Error[field] == "" {
+					t.Errorf("expected an error for %s, got %v", field, u.Error)
+				}
+			}
+			if len(u.Error) != len(tt.errors) {
+				t.Errorf("expected errors for %v, got %v", tt.errors, u.Error)
+			}
+			if u.Id == "" || u.Inserted.IsZero() {
+				t.Errorf("Id and Inserted weren't set")
+			}
+		})
+	}
+}
+
+func TestUserSlug(t *testing.T) {
+	tests := []struct {
+		nickName string
+		want     string
+	}{
+		{"chilts", "chilts"},
+		{"p", "p-2"},
+		{"Admin", "Admin-2"},
+		{"newbie", "newbie"},
+	}
+
+	for _, tt := range tests {
+		if got := userSlug(tt.nickName); got != tt.want {
+			t.Errorf("userSlug(%q) = %q, want %q", tt.nickName, got, tt.want)
+		}
+	}
+}
+
+func TestPrefsWants(t *testing.T) {
+	events := []string{EventNewComment, EventNewFollower, EventWeekEnding, EventNoUpdate}
+
+	all := DefaultPrefs()
+	for _, event := range events {
+		if !all.Wants(event) {
+			t.Errorf("default prefs don't want %s", event)
+		}
+	}
+
+	none := Prefs{}
+	for _, event := range events {
+		if none.Wants(event) {
+			t.Errorf("opted out prefs want %s", event)
+		}
+	}
+
+	some := Prefs{NewComment: true, WeekEnding: true}
+	want := map[string]bool{EventNewComment: true, EventNewFollower: false, EventWeekEnding: true, EventNoUpdate: false}
+	for event, w := range want {
+		if got := some.Wants(event); got != w {
+			t.Errorf("Wants(%s) = %v, want %v", event, got, w)
+		}
+	}
+
+	if all.Wants("something-else") {
+		t.Errorf("prefs want an unknown event")
+	}
+}
+
+func TestPrefsInQuietHours(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeZone string
+		from, to int
+		now      string // in UTC
+		quiet    bool
+	}{
+		{"none", "UTC", 8, 8, "2026-10-19T08:30:00Z", false},
+		{"same day, before", "UTC", 9, 17, "2026-10-19T08:59:00Z", false},
+		{"same day, from is quiet", "UTC", 9, 17, "2026-10-19T09:00:00Z", true},
+		{"same day, to isn't quiet", "UTC", 9, 17, "2026-10-19T17:00:00Z", false},
+		{"wraps, late", "UTC", 22, 8, "2026-10-19T23:30:00Z", true},
+		{"wraps, midnight", "UTC", 22, 8, "2026-10-19T00:00:00Z", true},
+		{"wraps, early", "UTC", 22, 8, "2026-10-19T07:59:00Z", true},
+		{"wraps, day", "UTC", 22, 8, "2026-10-19T12:00:00Z", false},
+		{"their evening is our morning", "Pacific/Auckland", 22, 8, "2026-10-19T10:00:00Z", true},  // 23:00 NZDT
+		{"their morning is our evening", "Pacific/Auckland", 22, 8, "2026-10-19T20:00:00Z", false}, // 09:00 NZDT
+		{"behind UTC", "America/New_York", 22, 8, "2026-10-19T03:00:00Z", true},                    // 23:00 EDT
+		{"unknown zone is UTC", "Nowhere/Special", 22, 8, "2026-10-19T23:00:00Z", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now, err := time.Parse(time.RFC3339, tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := Prefs{TimeZone: tt.timeZone, QuietFrom: tt.from, QuietTo: tt.to}
+			if got := p.InQuietHours(now); got != tt.quiet {
+				t.Errorf("InQuietHours(%s) = %v, want %v", tt.now, got, tt.quiet)
+			}
+		})
+	}
+}
+
+// goalsProject has three goals, of which "b" is already done by alice.
+func goalsProject() *Project {
+	done := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	return &Project{Goals: []Goal{
+		{Id: "a", Title: "Read the tour", Day: 1},
+		{Id: "b", Title: "Write a CLI", Day: 2, Done: true, DoneBy: "alice", DoneAt: done},
+		{Id: "c", Title: "Write a server", Day: 3},
+	}}
+}
+
+func TestCheckGoals(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		shown  []string
+		done   []string
+		newly  []string
+		states map[string]bool
+		by     map[string]string
+	}{
+		{"nothing changes", []string{"a", "b", "c"}, []string{"b"}, []string{}, map[string]bool{"a": false, "b": true, "c": false}, map[string]string{"b": "alice"}},
+		{"ticks one off", []string{"a", "b", "c"}, []string{"a", "b"}, []string{"a"}, map[string]bool{"a": true, "b": true, "c": false}, map[string]string{"a": "bob", "b": "alice"}},
+		{"unticks one", []string{"a", "b", "c"}, []string{}, []string{}, map[string]bool{"a": false, "b": false, "c": false}, map[string]string{"b": ""}},
+		{"goals not shown stay done", []string{"a", "c"}, []string{"c"}, []string{"c"}, map[string]bool{"a": false, "b": true, "c": true}, map[string]string{"b": "alice", "c": "bob"}},
+		{"goals not shown stay undone", []string{"b"}, []string{"a", "b", "c"}, []string{}, map[string]bool{"a": false, "b": true, "c": false}, map[string]string{"a": ""}},
+		{"unknown ids are ignored", []string{"a", "x"}, []string{"a", "x"}, []string{"a"}, map[string]bool{"a": true, "b": true, "c": false}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := goalsProject()
+			newly := p.CheckGoals(tt.shown, tt.done, "bob", now)
+
+			if strings.Join(newly, ",") != strings.Join(tt.newly, ",") {
+				t.Errorf("newly done = %v, want %v", newly, tt.newly)
+			}
+			for _, g := range p.Goals {
+				if g.Done != tt.states[g.Id] {
+					t.Errorf("goal %s done = %v, want %v", g.Id, g.Done, tt.states[g.Id])
+				}
+				if by, ok := tt.by[g.Id]; ok && g.DoneBy != by {
+					t.Errorf("goal %s done by %q, want %q", g.Id, g.DoneBy, by)
+				}
+				if !g.Done && !g.DoneAt.IsZero() {
+					t.Errorf("goal %s isn't done but has a time", g.Id)
+				}
+			}
+			// the one alice did keeps her time, unless it was unticked
+			if b := p.Goals[1]; b.Done && b.DoneAt.Equal(now) {
+				t.Errorf("goal b was done again")
+			}
+		})
+	}
+}
+
+func TestSetGoalStates(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	// the update's copy, from before "c" was renamed and "d" was added
+	p := goalsProject()
+	p.CheckGoals([]string{"a", "b", "c"}, []string{"a", "c"}, "bob", now)
+	states := p.Goals
+
+	current := goalsProject()
+	current.Goals[2].Title = "Write a web server"
+	current.Goals = append(current.Goals, Goal{Id: "d", Title: "Deploy it"})
+	current.SetGoalStates(states)
+
+	want := map[string]bool{"a": true, "b": false, "c": true, "d": false}
+	for _, g := range current.Goals {
+		if g.Done != want[g.Id] {
+			t.Errorf("goal %s done = %v, want %v", g.Id, g.Done, want[g.Id])
+		}
+	}
+	if current.Goals[2].Title != "Write a web server" || current.Goals[0].DoneBy != "bob" || !current.Goals[0].DoneAt.Equal(now) {
+		t.Errorf("goals = %+v", current.Goals)
+	}
+}
+
+func TestGoalProgress(t *testing.T) {
+	tests := []struct {
+		done, total int
+		progress    int
+	}{
+		{0, 0, 0},
+		{0, 3, 0},
+		{1, 3, 33},
+		{2, 3, 66},
+		{3, 3, 100},
+		{7, 7, 100},
+	}
+
+	for _, tt := range tests {
+		p := &Project{}
+		for i := 0; i < tt.total; i++ {
+			p.Goals = append(p.Goals, Goal{Id: string(rune('a' + i)), Done: i < tt.done})
+		}
+		if got := p.GoalProgress(); got != tt.progress {
+			t.Errorf("%d of %d done: GoalProgress() = %d, want %d", tt.done, tt.total, got, tt.progress)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	done := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	p := &Project{
+		Name:         "learn-go",
+		Title:        "Learn Go",
+		Content:      "In a week.",
+		UserName:     "chilts",
+		Visibility:   VisibilityUnlisted,
+		ShareToken:   "s3cret",
+		Tags:         []string{"go"},
+		Members:      []string{"alice"},
+		Progress:     80,
+		AutoProgress: true,
+		CrossPost:    true,
+		Goals: []Goal{
+			{Id: "a", Title: "Read the tour", Day: 1, Done: true, DoneBy: "alice", DoneAt: done},
+			{Id: "b", Title: "Write a CLI", Day: 2},
+		},
+	}
+
+	c := p.Clone("bob")
+
+	if c.UserName != "bob" || c.Title != "Learn Go" || c.Content != "In a week." || c.ClonedFrom != "chilts/learn-go" {
+		t.Errorf("clone = %+v", c)
+	}
+	if c.Progress != 0 || c.Visibility != "" || c.ShareToken != "" || len(c.Members) != 0 || c.CrossPost || c.Name != "" {
+		t.Errorf("clone kept the original's settings: %+v", c)
+	}
+	if len(c.Goals) != 2 || c.Goals[0].Title != "Read the tour" || c.Goals[0].Day != 1 {
+		t.Fatalf("goals = %+v", c.Goals)
+	}
+	for _, g := range c.Goals {
+		if g.Done || g.DoneBy != "" || !g.DoneAt.IsZero() {
+			t.Errorf("goal %s is still done: %+v", g.Id, g)
+		}
+	}
+	if !c.AutoProgress {
+		t.Error("AutoProgress wasn't kept")
+	}
+	if c.ClonedFromUrl() != "/u/chilts/p/learn-go/" {
+		t.Errorf("ClonedFromUrl = %q", c.ClonedFromUrl())
+	}
+
+	// changing the clone leaves the original alone
+	c.Tags[0] = "rust"
+	c.Goals[1].Title = "Write a server"
+	if p.Tags[0] != "go" || p.Goals[1].Title != "Write a CLI" {
+		t.Errorf("changing the clone changed the original: %+v", p)
+	}
+
+	// and it gets the default visibility when it's saved, rather than the original's
+	if !c.Validate() || c.Visibility != VisibilityPublic || c.ShareToken != "" {
+		t.Errorf("saved clone has visibility %q, share token %q and errors %v", c.Visibility, c.ShareToken, c.Error)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Machiel/slugify"
+)
+
+// The most chars (not bytes) each field can have. These are checked by Validate, whichever form or API the value came
+// from.
+const (
+	maxTitleLen   = 100
+	maxContentLen = 5000
+	maxStatusLen  = 1000
+	maxCommentLen = 1000
+	maxGoalLen    = 200
+)
+
+// reservedNames can't be used as a project's or a user's slug, since they clash with a route (e.g. `/p/new`), or may
+// one day if we move things to the top level.
+var reservedNames = map[string]bool{
+	"admin": true, "api": true, "auth": true, "badge": true, "comment": true, "dashboard": true, "delete": true,
+	"edit": true, "embed": true, "feed": true, "follow": true, "goals": true, "healthz": true, "kudos": true,
+	"login": true, "logout": true, "members": true, "metrics": true, "new": true, "p": true, "readyz": true,
+	"robots": true, "s": true, "search": true, "settings": true, "sitemap": true, "static": true, "t": true, "u": true,
+	"update": true, "webhooks": true,
+}
+
+// projectSlug returns the name a project with this title lives under. Titles which slugify can't transliterate (e.g.
+// Japanese, or just emoji) keep their letters and digits as they are, and if there are none it's just "project". It
+// may be reserved or already taken, which freeProjectName sorts out.
+func projectSlug(title string) string {
+	name := slugify.Slugify(title)
+	if name == "" {
+		words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		name = strings.Join(words, "-")
+	}
+	for strings.Contains(name, "--") {
+		name = strings.Replace(name, "--", "-", -1)
+	}
+	if name == "" {
+		name = "project"
+	}
+	return name
+}
+
+// userSlug returns the name a user lives under, which is their handle unless it's reserved. Handles can't contain a
+// "-" so adding one never clashes with anyone else.
+func userSlug(nickName string) string {
+	if reservedNames[strings.ToLower(nickName)] {
+		return nickName + "-2"
+	}
+	return nickName
+}
+
+// checkText sets a message in `errs` under `field` if `s` is shorter than `min` or longer than `max` chars, or if it
+// has any control characters. Multi-line text may also have newlines and tabs.
+func checkText(errs map[string]string, field, label, s string, min, max int, multiline bool) {
+	n := utf8.RuneCountInString(s)
+
+	if n == 0 && min > 0 {
+		errs[field] = label + " must be provided"
+		return
+	}
+	if n < min {
+		errs[field] = fmt.Sprintf("%s should be at least %s chars", label, commas(min))
+		return
+	}
+	if n > max {
+		errs[field] = fmt.Sprintf("%s should be less than %s chars", label, commas(max))
+		return
+	}
+
+	if !utf8.ValidString(s) {
+		errs[field] = label + " isn't valid text"
+		return
+	}
+	for _, r := range s {
+		if multiline && (r == '\n' || r == '\r' || r == '\t') {
+			continue
+		}
+		if unicode.IsControl(r) {
+			errs[field] = label + " can't contain control characters"
+			return
+		}
+	}
+}
+
+// checkRange sets a message in `errs` under `field` if `n` isn't between `min` and `max` inclusive.
+func checkRange(errs map[string]string, field, label string, n, min, max int) {
+	if n < min || n > max {
+		errs[field] = fmt.Sprintf("%s should be between %d and %d inclusive", label, min, max)
+	}
+}
+
+// commas formats a positive number with thousands separators, e.g. 5000 is "5,000".
+func commas(n int) string {
+	s := fmt.Sprintf("%d", n)
+	parts := make([]string, 0, len(s)/3+1)
+	for len(s) > 3 {
+		parts = append([]string{s[len(s)-3:]}, parts...)
+		s = s[:len(s)-3]
+	}
+	return strings.Join(append([]string{s}, parts...), ",")
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// visibilityProjectsIn puts a public, an unlisted and a private project of chilts' into the store, all tagged
+// "golang" with "rocket" in them and an update each, and with alice as a collaborator.
+func visibilityProjectsIn(t *testing.T, db *bolt.DB) (*Project, *Project, *Project) {
+	t.Helper()
+	testUser(t, db, "chilts")
+
+	projects := make([]*Project, 0, 3)
+	for _, visibility := range []string{VisibilityPublic, VisibilityUnlisted, VisibilityPrivate} {
+		p := &Project{
+			Title:      "Build a " + visibility + " rocket",
+			Content:    "Rocket science, in a week.",
+			UserName:   "chilts",
+			Visibility: visibility,
+			Tags:       []string{"golang"},
+			Members:    []string{"alice"},
+		}
+		if !p.Validate() {
+			t.Fatalf("project isn't valid: %v", p.Error)
+		}
+		if err := InsNewProject(db, p); err != nil {
+			t.Fatal(err)
+		}
+
+		now := time.Now().UTC()
+		u := Update{Id: now.Format(idFormat), Status: "The rocket flew", Progress: 50, Inserted: now, Updated: now}
+		if err := InsUpdate(db, *p, u); err != nil {
+			t.Fatal(err)
+		}
+		projects = append(projects, p)
+	}
+
+	return projects[0], projects[1], projects[2]
+}
+
+func TestCanView(t *testing.T) {
+	owner := &User{Name: "chilts"}
+	member := &User{Name: "alice"}
+	other := &User{Name: "bob"}
+
+	public := &Project{UserName: "chilts", Visibility: VisibilityPublic}
+	unlisted := &Project{UserName: "chilts", Visibility: VisibilityUnlisted, ShareToken: "s3cret", Members: []string{"alice"}}
+	private := &Project{UserName: "chilts", Visibility: VisibilityPrivate, ShareToken: "s3cret", Members: []string{"alice"}}
+	tokenless := &Project{UserName: "chilts", Visibility: VisibilityUnlisted}
+
+	tests := []struct {
+		name  string
+		p     *Project
+		user  *User
+		share string
+		want  bool
+	}{
+		{"public, signed out", public, nil, "", true},
+		{"public, someone else", public, other, "", true},
+		{"unlisted, signed out", unlisted, nil, "", false},
+		{"unlisted, signed out with the token", unlisted, nil, "s3cret", true},
+		{"unlisted, with the wrong token", unlisted, other, "s3cre", false},
+		{"unlisted, owner", unlisted, owner, "", true},
+		{"unlisted, collaborator", unlisted, member, "", true},
+		{"unlisted with no token, with an empty token", tokenless, nil, "", false},
+		{"private, signed out", private, nil, "", false},
+		{"private, with the token", private, other, "s3cret", false},
+		{"private, owner", private, owner, "", true},
+		{"private, collaborator", private, member, "", true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.p.CanView(tt.user, tt.share); got != tt.want {
+			t.Errorf("%s: CanView = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestListingsOnlyHavePublicProjects(t *testing.T) {
+	db := testDB(t)
+	public, unlisted, private := visibilityProjectsIn(t, db)
+	now := time.Now().UTC()
+
+	names := func(projects []*Project) string {
+		out := make([]string, 0, len(projects))
+		for _, p := range projects {
+			out = append(out, p.Name)
+		}
+		return strings.Join(out, ",")
+	}
+
+	t.Run("search", func(t *testing.T) {
+		tests := []struct {
+			user *User
+			want map[string]bool
+		}{
+			{nil, map[string]bool{public.Name: true}},
+			{&User{Name: "bob"}, map[string]bool{public.Name: true}},
+			{&User{Name: "chilts"}, map[string]bool{public.Name: true, unlisted.Name: true, private.Name: true}},
+			{&User{Name: "alice"}, map[string]bool{public.Name: true, unlisted.Name: true, private.Name: true}},
+		}
+		for _, tt := range tests {
+			results, err := SelSearch(db, "rocket", tt.user, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := make(map[string]bool)
+			for _, r := range results {
+				found[r.Project.Name] = true
+			}
+			if len(found) != len(tt.want) || len(results) != 2*len(tt.want) {
+				t.Errorf("%+v found %d results on %v, want the project and it's update for each of %v", tt.user, len(results), found, tt.want)
+			}
+			for name := range found {
+				if !tt.want[name] {
+					t.Errorf("%+v found %s", tt.user, name)
+				}
+			}
+		}
+	})
+
+	t.Run("tag page", func(t *testing.T) {
+		projects, _, err := SelTagProjects(db, "golang")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := names(projects); got != public.Name {
+			t.Errorf("tag page has %q, want %q", got, public.Name)
+		}
+	})
+
+	t.Run("tag cloud", func(t *testing.T) {
+		cloud, err := SelTagCloud(db, now.Add(-time.Hour), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cloud) != 1 || cloud[0].Tag != "golang" || cloud[0].Count != 1 {
+			t.Errorf("tag cloud = %+v, want golang once", cloud)
+		}
+
+		tags, err := SelTagsByPrefix(db, "go", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(tags) != 1 || tags[0].Count != 1 {
+			t.Errorf("tags = %+v, want golang once", tags)
+		}
+	})
+
+	t.Run("feed", func(t *testing.T) {
+		items, _, err := SelFeed(db, []string{"chilts"}, now.Add(-time.Hour), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 || items[0].Project.Name != public.Name {
+			t.Errorf("feed has %d items, want just the public project's update", len(items))
+		}
+	})
+
+	t.Run("sitemap", func(t *testing.T) {
+		sitemap, err := SelSitemap(db, "https://example.com", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range sitemap.Urls {
+			if strings.Contains(u.Loc, unlisted.Name) || strings.Contains(u.Loc, private.Name) {
+				t.Errorf("sitemap has %s", u.Loc)
+			}
+		}
+		if len(sitemap.Urls) != 4 {
+			t.Errorf("sitemap = %+v, want the home page, topics, chilts and the public project", sitemap.Urls)
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// how many times we try to deliver a payload before giving up on it
+var maxWebhookAttempts = 6
+
+// WebhookPayload is the JSON body posted to a webhook.
+type WebhookPayload struct {
+	Event    string         `json:"event"`
+	Delivery string         `json:"delivery"`
+	Sent     time.Time      `json:"sent"`
+	Project  WebhookProject `json:"project"`
+	Update   *WebhookUpdate `json:"update,omitempty"`
+}
+
+// WebhookProject is the part of a project sent in a webhook payload.
+type WebhookProject struct {
+	UserName string `json:"userName"`
+	Name     string `json:"name"`
+	Title    string `json:"title"`
+	Progress int    `json:"progress"`
+	Url      string `json:"url"`
+}
+
+// WebhookUpdate is the part of an update sent in a webhook payload.
+type WebhookUpdate struct {
+	Id       string `json:"id"`
+	Author   string `json:"author"`
+	Status   string `json:"status"`
+	Progress int    `json:"progress"`
+}
+
+// signWebhook returns the signature of `body` for the `X-WeekProject-Signature` header, which receivers can check by
+// computing the HMAC-SHA256 of the raw body with their webhook's secret.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Fire queues a delivery of `event` to each of the project owner's webhooks which wants it. The update may be nil.
+func (n *Notifier) Fire(p Project, event string, u *Update) error {
+	webhooks, bad, err := SelWebhooks(n.db, p.UserName)
+	if err != nil {
+		return err
+	}
+	logBadRecords(slog.Default(), bad)
+
+	for _, wh := range webhooks {
+		if !wh.Wants(&p, event) {
+			continue
+		}
+		_, err = n.queueDelivery(wh, p, event, u)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SendTest sends a ping for this webhook straight away, so the user can see the result in their delivery log. If it
+// fails it's retried like any other delivery.
+func (n *Notifier) SendTest(wh *Webhook, p Project) (Delivery, error) {
+	d, err := n.queueDelivery(wh, p, EventPing, nil)
+	if err != nil {
+		return d, err
+	}
+	err = n.deliver(&d, time.Now().UTC())
+	return d, err
+}
+
+// queueDelivery puts a new delivery for this webhook into the log and onto the queue.
+func (n *Notifier) queueDelivery(wh *Webhook, p Project, event string, u *Update) (Delivery, error) {
+	now := time.Now().UTC()
+
+	payload := WebhookPayload{
+		Event:    event,
+		Delivery: now.Format(idFormat) + "-" + randomHex(4),
+		Sent:     now,
+		Project: WebhookProject{
+			UserName: p.UserName,
+			Name:     p.Name,
+			Title:    p.Title,
+			Progress: p.Progress,
+			Url:      n.baseUrl + p.Url(),
+		},
+	}
+	if u != nil {
+		payload.Update = &WebhookUpdate{Id: u.Id, Author: u.Author, Status: u.Status, Progress: u.Progress}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	d := Delivery{
+		Id:          payload.Delivery,
+		UserName:    wh.UserName,
+		WebhookId:   wh.Id,
+		Url:         wh.Url,
+		Event:       event,
+		Payload:     body,
+		Status:      DeliveryPending,
+		NextAttempt: now,
+		Inserted:    now,
+		Updated:     now,
+	}
+	return d, InsDelivery(n.db, d)
+}
+
+// deliver makes one attempt at posting the delivery to it's webhook and saves the outcome. Anything other than a 2xx
+// response is a failure, which is retried later, backing off each time, until it has failed maxWebhookAttempts times.
+func (n *Notifier) deliver(d *Delivery, now time.Time) error {
+	wh, err := GetWebhook(n.db, d.UserName, d.WebhookId)
+	if err != nil {
+		return err
+	}
+
+	d.Attempts++
+	d.Updated = now
+
+	var errSend error
+	if wh.Id == "" {
+		d.Attempts = maxWebhookAttempts
+		errSend = fmt.Errorf("webhook has been deleted")
+	} else {
+		d.StatusCode, errSend = n.post(&wh, d)
+	}
+
+	switch {
+	case errSend == nil:
+		d.Status = DeliveryDelivered
+		d.LastError = ""
+		slog.Info("delivered webhook", "userName", d.UserName, "id", d.Id, "event", d.Event)
+	case d.Attempts >= maxWebhookAttempts:
+		d.Status = DeliveryFailed
+		d.LastError = errSend.Error()
+		slog.Warn("giving up on webhook", "userName", d.UserName, "id", d.Id, "event", d.Event, "attempts", d.Attempts, "err", errSend)
+	default:
+		d.LastError = errSend.Error()
+		d.NextAttempt = now.Add(30 * time.Second << uint(d.Attempts))
+		slog.Warn("delivering webhook", "userName", d.UserName, "id", d.Id, "event", d.Event, "attempts", d.Attempts, "err", errSend)
+	}
+
+	return UpdDelivery(n.db, *d)
+}
+
+// post sends the delivery's payload to the webhook, signed with the webhook's secret.
+func (n *Notifier) post(wh *Webhook, d *Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, wh.Url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "WeekProject-Webhook/1.0")
+	req.Header.Set("X-WeekProject-Event", d.Event)
+	req.Header.Set("X-WeekProject-Delivery", d.Id)
+	req.Header.Set("X-WeekProject-Signature", signWebhook(wh.Secret, d.Payload))
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook responded with %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// DeliverDue attempts every queued delivery which is due.
+func (n *Notifier) DeliverDue(now time.Time) error {
+	due, bad, err := SelDueDeliveries(n.db, now, 100)
+	if err != nil {
+		return err
+	}
+	logBadRecords(slog.Default(), bad)
+
+	for _, d := range due {
+		err = n.deliver(d, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
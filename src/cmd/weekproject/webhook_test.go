@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// webhookReceiver is a webhook which answers each request with the next of `codes`, then 200, recording what it's
+// sent.
+type webhookReceiver struct {
+	codes    []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	wr.requests = append(wr.requests, r)
+	wr.bodies = append(wr.bodies, body)
+
+	code := http.StatusOK
+	if len(wr.codes) > 0 {
+		code, wr.codes = wr.codes[0], wr.codes[1:]
+	}
+	w.WriteHeader(code)
+}
+
+// testWebhook sets up a project with a webhook to `receiver`, and a notifier which can reach it.
+func testWebhook(t *testing.T, receiver http.Handler) (*bolt.DB, *Notifier, *Project, Webhook) {
+	t.Helper()
+
+	srv := httptest.NewServer(receiver)
+	t.Cleanup(srv.Close)
+
+	db := testDB(t)
+	p := testProject(t, db, "bob", "Learn Go", VisibilityPublic)
+
+	// straight into the store, since Validate won't allow the test server's loopback address
+	wh := Webhook{Id: "1", UserName: "bob", Url: srv.URL, Events: []string{EventUpdatePosted}, Secret: "sekrit"}
+	if err := InsWebhook(db, wh); err != nil {
+		t.Fatal(err)
+	}
+
+	n := NewNotifier(db, failMailer{}, nil, "https://example.com")
+	n.client = srv.Client()
+	return db, n, p, wh
+}
+
+// deliveries returns bob's delivery log, newest first.
+func deliveries(t *testing.T, db *bolt.DB) []*Delivery {
+	t.Helper()
+	log, _, err := SelDeliveries(db, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return log
+}
+
+func TestWebhookSignature(t *testing.T) {
+	receiver := &webhookReceiver{}
+	db, n, p, _ := testWebhook(t, receiver)
+
+	u := &Update{Id: "u1", Author: "bob", Status: "Read the tour", Progress: 20}
+	if err := n.Fire(*p, EventUpdatePosted, u); err != nil {
+		t.Fatal(err)
+	}
+	// it isn't wanted by the webhook, so isn't queued
+	if err := n.Fire(*p, EventProjectCompleted, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.DeliverDue(time.Now().UTC()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(receiver.requests) != 1 {
+		t.Fatalf("received %d requests, want 1", len(receiver.requests))
+	}
+	r, body := receiver.requests[0], receiver.bodies[0]
+
+	mac := hmac.New(sha256.New, []byte("sekrit"))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get("X-WeekProject-Signature") != want {
+		t.Errorf("signature = %q, want %q", r.Header.Get("X-WeekProject-Signature"), want)
+	}
+	if r.Header.Get("X-WeekProject-Event") != EventUpdatePosted {
+		t.Errorf("event header = %q", r.Header.Get("X-WeekProject-Event"))
+	}
+
+	payload := WebhookPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Delivery != r.Header.Get("X-WeekProject-Delivery") {
+		t.Errorf("delivery header = %q, payload has %q", r.Header.Get("X-WeekProject-Delivery"), payload.Delivery)
+	}
+	if payload.Project.Url != "https://example.com/u/bob/p/learn-go/" || payload.Update == nil || payload.Update.Id != "u1" {
+		t.Errorf("payload = %+v", payload)
+	}
+
+	log := deliveries(t, db)
+	if len(log) != 1 || log[0].Status != DeliveryDelivered || log[0].StatusCode != http.StatusOK || log[0].Attempts != 1 {
+		t.Errorf("delivery log = %+v", log)
+	}
+}
+
+func TestDeliverDueRetries(t *testing.T) {
+	receiver := &webhookReceiver{codes: []int{500, 503}}
+	db, n, p, _ := testWebhook(t, receiver)
+
+	if err := n.Fire(*p, EventUpdatePosted, nil); err != nil {
+		t.Fatal(err)
+	}
+	now := deliveries(t, db)[0].NextAttempt
+
+	// each failure is logged and waits twice as long as the last before trying again
+	for attempt, code := range []int{500, 503} {
+		if err := n.DeliverDue(now); err != nil {
+			t.Fatal(err)
+		}
+
+		d := deliveries(t, db)[0]
+		wait := 30 * time.Second << uint(attempt+1)
+		if d.Status != DeliveryPending || d.Attempts != attempt+1 || d.StatusCode != code || d.LastError == "" {
+			t.Fatalf("attempt %d: delivery = %+v", attempt+1, d)
+		}
+		if !d.NextAttempt.Equal(now.Add(wait)) {
+			t.Fatalf("attempt %d: next attempt in %v, want %v", attempt+1, d.NextAttempt.Sub(now), wait)
+		}
+
+		// nothing is sent until the wait is up
+		if err := n.DeliverDue(now.Add(wait - time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if len(receiver.requests) != attempt+1 {
+			t.Fatalf("attempt %d: sent %d times, before the backoff was up", attempt+1, len(receiver.requests))
+		}
+		now = now.Add(wait)
+	}
+
+	if err := n.DeliverDue(now); err != nil {
+		t.Fatal(err)
+	}
+	d := deliveries(t, db)[0]
+	if d.Status != DeliveryDelivered || d.Attempts != 3 || d.StatusCode != http.StatusOK || d.LastError != "" {
+		t.Fatalf("delivery = %+v", d)
+	}
+
+	// and it's off the queue
+	due, _, err := SelDueDeliveries(db, now.Add(24*time.Hour), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 0 {
+		t.Errorf("%d deliveries still queued", len(due))
+	}
+}
+
+func TestDeliverDueGivesUp(t *testing.T) {
+	codes := make([]int, maxWebhookAttempts)
+	for i := range codes {
+		codes[i] = http.StatusInternalServerError
+	}
+	receiver := &webhookReceiver{codes: codes}
+	db, n, p, _ := testWebhook(t, receiver)
+
+	if err := n.Fire(*p, EventUpdatePosted, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// far enough ahead that every retry is due
+	now := time.Now().UTC()
+	for i := 0; i < maxWebhookAttempts+2; i++ {
+		now = now.Add(24 * time.Hour)
+		if err := n.DeliverDue(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(receiver.requests) != maxWebhookAttempts {
+		t.Errorf("sent %d times, want %d", len(receiver.requests), maxWebhookAttempts)
+	}
+	d := deliveries(t, db)[0]
+	if d.Status != DeliveryFailed || d.Attempts != maxWebhookAttempts || d.StatusCode != http.StatusInternalServerError {
+		t.Errorf("delivery = %+v", d)
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://93.184.216.34/hooks/abc", true},
+		{"ftp://93.184.216.34/hooks/abc", false},
+		{"http://127.0.0.1:8080/", false},
+		{"http://169.254.169.254/latest/meta-data/", false},
+		{"http://10.0.0.1/", false},
+		{"http://[::1]/", false},
+	}
+
+	for _, tt := range tests {
+		wh := Webhook{UserName: "bob", Url: tt.url, Events: []string{EventUpdatePosted}}
+		if wh.Validate() != tt.ok {
+			t.Errorf("Validate(%q) = %v, want %v: %v", tt.url, !tt.ok, tt.ok, wh.Error)
+		}
+		if tt.ok && wh.Secret == "" {
+			t.Errorf("Validate(%q) didn't make a secret", tt.url)
+		}
+	}
+}
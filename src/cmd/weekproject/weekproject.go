@@ -2,17 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/Machiel/slugify"
 	"github.com/boltdb/bolt"
-	"github.com/gomiddleware/logger"
-	"github.com/gorilla/pat"
 	"github.com/gorilla/schema"
 	"github.com/gorilla/sessions"
 	"github.com/markbates/goth"
@@ -30,6 +36,13 @@ var sessionStore = sessions.NewCookieStore(
 var tmpl *template.Template
 var sessionName = "session"
 
+// how long we wait for in-flight requests to finish when shutting down
+var shutdownTimeout = 30 * time.Second
+
+// how far back, and how many updates, the dashboard shows
+var dashboardPeriod = 14 * 24 * time.Hour
+var dashboardLimit = 50
+
 var decoder = schema.NewDecoder()
 
 func check(err error) {
@@ -39,13 +52,13 @@ func check(err error) {
 }
 
 func render(w http.ResponseWriter, tmplName string, data interface{}) {
-	log.Printf("render(): entry, name=%s", tmplName)
-	defer log.Printf("render(): exit")
-
+	start := time.Now()
 	buf := &bytes.Buffer{}
 	err := tmpl.ExecuteTemplate(buf, tmplName, data)
+	renderDuration.Since(start, tmplName)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		slog.Error("render", "tmpl", tmplName, "err", err)
+		renderErrorPage(w, internalError(err), nil)
 		return
 	}
 
@@ -72,47 +85,105 @@ func init() {
 	// tell gothic where our session store is
 	gothic.Store = sessionStore
 
+	// Register the user and their credential with `gob` so we can serialise them.
+	gob.Register(&User{})
+	gob.Register(&Credential{})
+}
+
+// loadTemplates parses every template in `dir`. It's called from main rather than init so that the tests can run
+// without them.
+func loadTemplates(dir string) {
 	funcMap := template.FuncMap{
 		// The name "inc" is what the function will be called in the template text.
 		"inc": func(i int) int {
 			return i + 1
 		},
+		"pageMeta":      pageMeta,
+		"attachmentsOf": attachmentsOf,
+		"authUrl":       authUrl,
 	}
 
 	// don't need `.Delims("[[", "]]")` since we're not using Vue.js here
-	tmpl1, err := template.New("").Funcs(funcMap).ParseGlob("./templates/*.html")
+	tmpl1, err := template.New("").Funcs(funcMap).ParseGlob(dir + "/*.html")
 	if err != nil {
 		log.Fatal(err)
 	}
 	tmpl = tmpl1
-
-	// Register the user with `gob` so we can serialise it.
-	gob.Register(&User{})
 }
 
 func main() {
+	loadTemplates("./templates")
+
 	baseUrl := os.Getenv("BASE_URL")
 	port := os.Getenv("PORT")
 
+	// logging
+	setupLogging(os.Stdout, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+
 	// open the store
 	db, errBoltOpen := bolt.Open("weekproject.db", 0666, &bolt.Options{Timeout: 1 * time.Second})
 	check(errBoltOpen)
-	defer db.Close()
+
+	// if we've been given a command, run that instead of the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "integrity":
+			check(integrityCmd(db, os.Stdout))
+		case "reindex":
+			n, err := RebuildIndex(db)
+			check(err)
+			fmt.Printf("reindexed %d projects and updates\n", n)
+			n, err = RebuildBlobOwners(db)
+			check(err)
+			fmt.Printf("recorded the owners of %d blobs\n", n)
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+		check(db.Close())
+		return
+	}
 
 	// Goth example setup : https://publish.li/goth-example-TQEVYjoH
 
 	// twitter
 	twitterConsumerKey := os.Getenv("TWITTER_CONSUMER_KEY")
 	twitterSecretKey := os.Getenv("TWITTER_SECRET_KEY")
-	twitter := twitter.NewAuthenticate(twitterConsumerKey, twitterSecretKey, baseUrl+"/auth/twitter/callback")
+	twitter := twitter.NewAuthenticate(twitterConsumerKey, twitterSecretKey, baseUrl+authUrl("")+"/callback")
+
+	// cross-posting updates, which can be faked in development with POSTER=fake, and the key for the credentials it uses
+	setCredentialKey(os.Getenv("CREDENTIAL_KEY"))
+	purged, errPurge := PurgePlainCredentials(db)
+	check(errPurge)
+	if purged > 0 {
+		slog.Info("purged unencrypted credentials", "count", purged)
+	}
+	posters := map[string]Poster{"twitter": NewTwitterPoster(twitterConsumerKey, twitterSecretKey)}
+	if os.Getenv("POSTER") == "fake" {
+		posters = map[string]Poster{"twitter": &FakePoster{}}
+	}
+
+	// email notifications, which are queued and sent in the background
+	mailer, errMailer := newMailer()
+	check(errMailer)
+	notifier := NewNotifier(db, mailer, posters, baseUrl)
+
+	// attachments, kept on disk in BLOB_DIR
+	blobDir := os.Getenv("BLOB_DIR")
+	if blobDir == "" {
+		blobDir = "blobs"
+	}
+	blobs, errBlobs := NewDiskBlobStore(blobDir)
+	check(errBlobs)
 
 	// goth
 	goth.UseProviders(twitter)
 
-	// router
-	p := pat.New()
+	// router, which times and counts every request by route
+	p := newRouter()
+	p.NotFoundHandler = http.HandlerFunc(notFound)
 
 	p.PathPrefix("/s/").Handler(http.FileServer(http.Dir("static")))
+	p.Get("/a/{key}", serveBlob(db, blobs))
 
 	p.Get("/auth/{provider}/callback", func(w http.ResponseWriter, r *http.Request) {
 		session, _ := sessionStore.Get(r, sessionName)
@@ -122,17 +193,22 @@ func main() {
 
 		authUser, err := gothic.CompleteUserAuth(w, r)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			renderError(w, r, badRequest("Sorry, we couldn't sign you in. Please try again.", err))
 			return
 		}
 
-		fmt.Printf("provider=%s\n", provider)
-		fmt.Printf("authUser.Provider=%s\n", authUser.Provider)
-		fmt.Printf("auth=%#v\n", authUser)
+		lg := logFor(r)
+		lg.Info("auth callback", "provider", provider, "userId", authUser.UserID, "nickName", authUser.NickName)
 
 		// set this info in the session
+		// a handle which is reserved gets a name which isn't, unless they signed up before it was reserved
+		name := userSlug(authUser.NickName)
+		if existing, err := GetUser(db, authUser.NickName); err == nil && existing.Name != "" {
+			name = existing.Name
+		}
+
 		session.Values["id"] = authUser.UserID
-		session.Values["name"] = authUser.NickName
+		session.Values["name"] = name
 		session.Values["title"] = authUser.Name
 		session.Values["email"] = authUser.Email
 
@@ -145,40 +221,59 @@ func main() {
 		// save this social and user to the store
 		social := Social{
 			Id:   authUser.Provider + "-" + authUser.UserID,
-			Name: authUser.NickName,
+			Name: name,
 		}
 		user := User{
-			Name:  authUser.NickName,
+			Name:  name,
 			Title: authUser.Name,
 			Email: authUser.Email,
 		}
 
 		newSocial, err := InsSocial(db, social)
 		if err != nil {
-			log.Printf("err inserting social: %v\n", err)
+			lg.Error("inserting social", "socialId", social.Id, "err", err)
 		}
 
 		newUser, err := InsUser(db, user)
 		if err != nil {
-			log.Printf("err inserting user: %v\n", err)
+			lg.Error("inserting user", "userName", user.Name, "err", err)
+		}
+
+		// keep their access token in their session, so we can cross-post their updates if they ask us to, and refresh
+		// the one in the store if they already have
+		credential := Credential{
+			Provider: authUser.Provider,
+			Token:    authUser.AccessToken,
+			Secret:   authUser.AccessTokenSecret,
+			Updated:  time.Now().UTC(),
+		}
+		session.Values["credential"] = &credential
+		using, err := crossPosting(db, newUser.Name)
+		if err != nil {
+			lg.Error("checking cross-posting", "userName", newUser.Name, "err", err)
+		}
+		if using {
+			err = PutCredential(db, newUser.Name, credential)
+			if err != nil {
+				lg.Error("saving credential", "userName", newUser.Name, "provider", credential.Provider, "err", err)
+			}
 		}
 
-		fmt.Printf("social=%#v\n", social)
-		fmt.Printf("user=%#v\n", user)
-		fmt.Printf("new social=%#v\n", newSocial)
-		fmt.Printf("new user=%#v\n", newUser)
+		lg.Debug("signed in", "socialId", newSocial.Id, "userName", newUser.Name)
 
 		session.Values["user"] = &newUser
 
+		// back to where they were, if anywhere
+		returnTo := popReturnTo(r, "/p/")
+
 		// save all sessions
 		sessions.Save(r, w)
 
-		// redirect to somewhere else
-		http.Redirect(w, r, "/p/", http.StatusFound)
+		http.Redirect(w, r, returnTo, http.StatusFound)
 	})
 
 	// begin auth
-	p.Get("/auth/{provider}", gothic.BeginAuthHandler)
+	p.Get("/auth/{provider}", beginAuth)
 
 	// logout
 	p.Get("/logout", func(w http.ResponseWriter, r *http.Request) {
@@ -190,75 +285,39 @@ func main() {
 		delete(session.Values, "title")
 		delete(session.Values, "email")
 		delete(session.Values, "user")
+		delete(session.Values, "credential")
 		session.Save(r, w)
 
 		// redirect to somewhere else
 		http.Redirect(w, r, "/", http.StatusFound)
 	})
 
-	// Publicly Viewable Projects
-	p.Get("/u/{userName}/p/{projectName}/", func(w http.ResponseWriter, r *http.Request) {
-		// get this provider name from the URL
-		userName := r.URL.Query().Get(":userName")
-		projectName := r.URL.Query().Get(":projectName")
-
-		fmt.Printf("userName=%s\n", userName)
-		fmt.Printf("projectName=%s\n", projectName)
-
-		// try and retrieve this project from the store
-		p, err := GetProject(db, userName, projectName)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if p.Name == "" {
-			http.NotFound(w, r)
-			return
-		}
-
-		// get a list of updates
-		updates, err := SelUpdates(db, userName, projectName)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		// now check to see if a user is logged in
-		session, _ := sessionStore.Get(r, sessionName)
-		user := getUserFromSession(session)
-
-		fmt.Printf("Path=%s\n", r.URL.Path)
-		data := struct {
-			Title    string
-			SubTitle string
-			User     *User
-			Project  Project
-			Updates  []*Update
-		}{
-			p.Title,
-			"by @" + p.UserName,
-			user,
-			p,
-			updates,
-		}
-		render(w, "u-user-p-project.html", data)
-	})
-
-	// Publicly Viewable Projects
-	p.Get("/u/{userName}/p/{projectName}", toSlash)
-
 	// Projects
-	p.Get("/p/new", func(w http.ResponseWriter, r *http.Request) {
+	p.Get("/p/new", requireUser(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/p/new" {
-			http.NotFound(w, r)
+			renderError(w, r, errNotFound)
 			return
 		}
 
-		session, _ := sessionStore.Get(r, sessionName)
-		user := getUserFromSession(session)
-		if user == nil {
-			http.Redirect(w, r, "/", http.StatusFound)
-			return
+		user := userFor(r)
+
+		// start from a starter or a clone of a public project, if asked
+		project := &Project{}
+		starter := GetStarter(r.URL.Query().Get("starter"))
+		if starter != nil {
+			project = starter.Project()
+		}
+		if clone := r.URL.Query().Get("clone"); clone != "" {
+			from, err := getCloneable(db, clone)
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+			if from == nil {
+				renderError(w, r, errNotFound)
+				return
+			}
+			project = from.Clone(user.Name)
 		}
 
 		// render the new form
@@ -266,81 +325,130 @@ func main() {
 			Title    string
 			SubTitle string
 			User     *User
+			Project  *Project
+			Starter  *Starter
+			Starters []Starter
 		}{
 			"New Project",
 			"",
 			user,
+			project,
+			starter,
+			Starters,
 		}
 		render(w, "p-new.html", data)
-	})
+	}))
 
-	p.Post("/p/new", func(w http.ResponseWriter, r *http.Request) {
-		session, _ := sessionStore.Get(r, sessionName)
-		user := getUserFromSession(session)
-		if user == nil {
-			http.Redirect(w, r, "/", http.StatusFound)
-			return
-		}
+	p.Post("/p/new", requireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := userFor(r)
 
 		errParseForm := r.ParseForm()
 		if errParseForm != nil {
-			http.Error(w, errParseForm.Error(), http.StatusInternalServerError)
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errParseForm))
 			return
 		}
 
+		// which starter it came from isn't part of the project itself
+		starter := GetStarter(r.PostForm.Get("Starter"))
+		r.PostForm.Del("Starter")
+
 		project := Project{}
 		errDecode := decoder.Decode(&project, r.PostForm)
 		if errDecode != nil {
-			http.Error(w, errDecode.Error(), http.StatusInternalServerError)
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errDecode))
 			return
 		}
 		project.UserName = user.Name
 
+		// the goals come from the starter, or from the project it was cloned from
+		if starter != nil {
+			project.Goals = starter.Project().Goals
+		}
+
+		// only keep where it was cloned from if that really is a public project
+		if project.ClonedFrom != "" {
+			from, err := getCloneable(db, project.ClonedFrom)
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+			if from == nil {
+				project.ClonedFrom = ""
+			} else {
+				project.Goals = from.Clone(user.Name).Goals
+			}
+		}
+
 		if project.Validate() == false {
-			fmt.Printf("validation errors = %#v\n", project.Error)
+			logFor(r).Debug("project validation", "errors", project.Error)
+			data := struct {
+				Title    string
+				SubTitle string
+				User     *User
+				Project  *Project
+				Starter  *Starter
+				Starters []Starter
+			}{
+				"New Project",
+				"",
+				user,
+				&project,
+				starter,
+				Starters,
+			}
+			render(w, "p-new.html", data)
+			return
 		}
 
-		err := InsProject(db, project)
+		err := InsNewProject(db, &project)
 		if err != nil {
-			// ToDo: re-render the form with errors
-			http.Redirect(w, r, "/p/new", http.StatusFound)
+			renderError(w, r, err)
 			return
 		}
 
+		err = notifier.Fire(project, EventProjectCreated, nil)
+		if err != nil {
+			logFor(r).Error("firing webhooks", "event", EventProjectCreated, "err", err)
+		}
+
 		// all good
 		http.Redirect(w, r, "/p/"+project.Name+"/", http.StatusFound)
-	})
+	}))
 
-	// Add an update to a project.
-	p.Get("/p/{projectName}/update", func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("GET /p/{projectName}/update : entry\n")
-		defer log.Printf("GET /p/{projectName}/update : exit\n")
+	// Give (or take back) kudos on an update. This must come before the `/u/.../update` paths.
+	p.Post("/u/{userName}/p/{projectName}/update/{updateId}/kudos", requireUser(loadProject(db, signedIn, func(w http.ResponseWriter, r *http.Request) {
+		p := projectFor(r)
+		user := userFor(r)
 
-		session, _ := sessionStore.Get(r, sessionName)
-		user := getUserFromSession(session)
-		if user == nil {
-			log.Printf("/p/{projectName}/update : no user\n")
-			http.Redirect(w, r, "/", http.StatusFound)
+		share := r.URL.Query().Get("share")
+		if !p.CanView(user, share) {
+			renderError(w, r, errNotFound)
 			return
 		}
 
-		// get this project name from the URL
-		projectName := r.URL.Query().Get(":projectName")
-		log.Printf("/p/{projectName}/update : projectName=%s\n", projectName)
-
-		// try and retrieve this project from the store
-		p, err := GetProject(db, user.Name, projectName)
-		if err != nil {
-			log.Printf("/p/{projectName}/ : err GetProject : %v\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		updateId := r.URL.Query().Get(":updateId")
+		_, err := ToggleKudos(db, *p, updateId, user.Name)
+		if err == ErrNoSuchUpdate {
+			renderError(w, r, errNotFound)
 			return
 		}
-		if p.Name == "" {
-			log.Printf("/p/{projectName}/ : Not Found\n")
-			http.NotFound(w, r)
+		if err != nil {
+			renderError(w, r, err)
 			return
 		}
 
+		// the owner may have come from their own view of the project
+		back := viewUrl(p, share)
+		if r.PostFormValue("From") == "owner" && p.CanEdit(user) {
+			back = "/p/" + p.Name + "/"
+		}
+		http.Redirect(w, r, back, http.StatusFound)
+	})))
+
+	// Add an update to a project. Collaborators use the `/u/...` paths, since the project isn't under their user.
+	projectUpdateForm := requireUser(loadProject(db, (*Project).CanUpdate, func(w http.ResponseWriter, r *http.Request) {
+		p := projectFor(r)
+
 		data := struct {
 			Title    string
 			SubTitle string
@@ -350,58 +458,49 @@ func main() {
 		}{
 			p.Title,
 			"",
-			user,
-			&p,
+			userFor(r),
+			p,
 			&Update{},
 		}
 		render(w, "p-project-update.html", data)
-	})
-
-	// Add an update to a project.
-	p.Post("/p/{projectName}/update", func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("POST /p/{projectName}/update : entry\n")
-		defer log.Printf("POST /p/{projectName}/update : exit\n")
-
-		session, _ := sessionStore.Get(r, sessionName)
-		user := getUserFromSession(session)
-		if user == nil {
-			log.Printf("/p/{projectName}/update : no user\n")
-			http.Redirect(w, r, "/", http.StatusFound)
-			return
-		}
+	}))
 
-		// get this project name from the URL
-		projectName := r.URL.Query().Get(":projectName")
-		log.Printf("/p/{projectName}/update : projectName=%s\n", projectName)
+	projectUpdate := requireUser(loadProject(db, (*Project).CanUpdate, func(w http.ResponseWriter, r *http.Request) {
+		p := projectFor(r)
+		user := userFor(r)
 
-		// try and retrieve this project from the store
-		p, err := GetProject(db, user.Name, projectName)
-		if err != nil {
-			log.Printf("/p/{projectName}/ : err GetProject : %v\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		if p.Name == "" {
-			log.Printf("/p/{projectName}/ : Not Found\n")
-			http.NotFound(w, r)
-			return
+		// get the incoming form, which has any attachments
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+		errParseForm := r.ParseMultipartForm(1 << 20)
+		if errParseForm == http.ErrNotMultipart {
+			errParseForm = r.ParseForm()
 		}
-
-		// get the incoming form
-		errParseForm := r.ParseForm()
 		if errParseForm != nil {
-			http.Error(w, errParseForm.Error(), http.StatusInternalServerError)
+			renderError(w, r, badRequest(fmt.Sprintf("Sorry, we couldn't read that form. Attachments can be up to %d MB each.", maxAttachmentSize>>20), errParseForm))
 			return
 		}
+		if r.MultipartForm != nil {
+			defer r.MultipartForm.RemoveAll()
+		}
 
 		update := Update{}
 		errDecode := decoder.Decode(&update, r.PostForm)
 		if errDecode != nil {
-			http.Error(w, errDecode.Error(), http.StatusInternalServerError)
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errDecode))
 			return
 		}
 
-		if update.Validate() == false {
+		valid := update.Validate()
+		if valid && r.MultipartForm != nil {
+			err := update.Attach(blobs, r.MultipartForm.File["Attachments"])
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+			valid = len(update.Error) == 0
+		}
+
+		if valid == false {
 			data := struct {
 				Title    string
 				SubTitle string
@@ -412,52 +511,56 @@ func main() {
 				p.Title,
 				"",
 				user,
-				&p,
+				p,
 				&update,
 			}
 			render(w, "p-project-update.html", data)
 			return
 		}
 
-		errInsUpdate := InsUpdate(db, p, update)
-		if errInsUpdate != nil {
-			fmt.Printf("error inserting update = %#v\n", errInsUpdate)
-			http.Redirect(w, r, "/p/"+projectName+"/update", http.StatusFound)
-			return
-		}
+		update.Author = user.Name
 
-		http.Redirect(w, r, "/p/"+projectName+"/", http.StatusFound)
-	})
+		// tick off the goals, keeping just those newly done on the update
+		update.Done = p.CheckGoals(update.Goals, update.Done, user.Name, update.Inserted)
+		if p.AutoProgress {
+			update.Progress = p.GoalProgress()
+		}
 
-	// Edit a project.
-	p.Get("/p/{projectName}/edit", func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("GET /p/{projectName}/edit : entry\n")
-		defer log.Printf("GET /p/{projectName}/edit : exit\n")
+		// the owner goes back to their own view of the project, collaborators to the public one
+		back := p.Url()
+		if p.CanEdit(user) {
+			back = "/p/" + p.Name + "/"
+		}
 
-		session, _ := sessionStore.Get(r, sessionName)
-		user := getUserFromSession(session)
-		if user == nil {
-			log.Printf("/p/{projectName}/edit : no user\n")
-			http.Redirect(w, r, "/", http.StatusFound)
+		errInsUpdate := InsUpdate(db, *p, update)
+		if errInsUpdate != nil {
+			update.Unattach(blobs)
+			renderError(w, r, internalError(errInsUpdate))
 			return
 		}
 
-		// get this project name from the URL
-		projectName := r.URL.Query().Get(":projectName")
-		log.Printf("/p/{projectName}/edit : projectName=%s\n", projectName)
-
-		// try and retrieve this project from the store
-		p, err := GetProject(db, user.Name, projectName)
-		if err != nil {
-			log.Printf("/p/{projectName}/ : err GetProject : %v\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		completed := p.Progress < 100 && update.Progress >= 100
+		p.Progress = update.Progress
+		err := notifier.Fire(*p, EventUpdatePosted, &update)
+		if err == nil && completed {
+			err = notifier.Fire(*p, EventProjectCompleted, &update)
 		}
-		if p.Name == "" {
-			log.Printf("/p/{projectName}/ : Not Found\n")
-			http.NotFound(w, r)
-			return
+		if err != nil {
+			logFor(r).Error("firing webhooks", "userName", p.UserName, "projectName", p.Name, "err", err)
 		}
+		notifier.CrossPost(*p, update)
+
+		http.Redirect(w, r, back, http.StatusFound)
+	}))
+
+	p.Get("/p/{projectName}/update", projectUpdateForm)
+	p.Post("/p/{projectName}/update", projectUpdate)
+	p.Get("/u/{userName}/p/{projectName}/update", projectUpdateForm)
+	p.Post("/u/{userName}/p/{projectName}/update", projectUpdate)
+
+	// Edit a project.
+	p.Get("/p/{projectName}/edit", requireUser(loadProject(db, (*Project).CanEdit, func(w http.ResponseWriter, r *http.Request) {
+		p := projectFor(r)
 
 		data := struct {
 			Title    string
@@ -468,132 +571,1124 @@ func main() {
 		}{
 			p.Title,
 			"",
-			user,
-			&p,
+			userFor(r),
+			p,
 			&Update{},
 		}
 		render(w, "p-project-edit.html", data)
-	})
+	})))
 
-	// Specific Project
-	p.Get("/p/{projectName}/", func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("/p/{projectName}/ : entry\n")
-		defer log.Printf("/p/{projectName}/ : exit\n")
+	// Save the edited project.
+	p.Post("/p/{projectName}/edit", requireUser(loadProject(db, (*Project).CanEdit, func(w http.ResponseWriter, r *http.Request) {
+		p := projectFor(r)
 
-		session, _ := sessionStore.Get(r, sessionName)
-		user := getUserFromSession(session)
-		if user == nil {
-			log.Printf("/p/{projectName}/ : no user\n")
-			http.Redirect(w, r, "/", http.StatusFound)
+		errParseForm := r.ParseForm()
+		if errParseForm != nil {
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errParseForm))
 			return
 		}
 
-		// get this provider name from the URL
-		projectName := r.URL.Query().Get(":projectName")
-		log.Printf("/p/{projectName}/ : projectName=%s\n", projectName)
+		// only the fields in the form are changed, though an unticked checkbox isn't sent at all
+		edited := *p
+		edited.CommentsDisabled = false
+		edited.CrossPost = false
+		edited.Tags = nil
+		edited.AutoProgress = false
+		errDecode := decoder.Decode(&edited, r.PostForm)
+		if errDecode != nil {
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errDecode))
+			return
+		}
+		edited.ClonedFrom = p.ClonedFrom
+
+		// cross-posting needs the credential from when they signed in, which we only keep once they turn it on
+		valid := edited.ValidateEdit()
+		var credential *Credential
+		if valid && edited.CrossPost && !p.CrossPost {
+			session, _ := sessionStore.Get(r, sessionName)
+			credential, _ = session.Values["credential"].(*Credential)
+			switch {
+			case credentialKey == nil:
+				edited.Error["CrossPost"] = "Sorry, cross-posting isn't available at the moment."
+			case credential == nil || credential.Token == "":
+				edited.Error["CrossPost"] = "Please sign out and back in, so that we can post for you."
+			}
+			valid = len(edited.Error) == 0
+		}
+
+		if valid == false {
+			data := struct {
+				Title    string
+				SubTitle string
+				User     *User
+				Project  *Project
+			}{
+				p.Title,
+				"",
+				userFor(r),
+				&edited,
+			}
+			render(w, "p-project-edit.html", data)
+			return
+		}
+
+		if credential != nil {
+			err := PutCredential(db, p.UserName, *credential)
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+		}
+
+		err := InsProject(db, edited)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		// and once they've turned it off everywhere, the credential goes
+		if p.CrossPost && !edited.CrossPost {
+			using, err := crossPosting(db, p.UserName)
+			if err == nil && !using {
+				err = DelCredentials(db, p.UserName)
+			}
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+		}
+
+		http.Redirect(w, r, "/p/"+p.Name+"/", http.StatusFound)
+	})))
+
+	// Add or remove a collaborator.
+	p.Post("/p/{projectName}/members", requireUser(loadProject(db, (*Project).CanEdit, func(w http.ResponseWriter, r *http.Request) {
+		p := projectFor(r)
+
+		errParseForm := r.ParseForm()
+		if errParseForm != nil {
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errParseForm))
+			return
+		}
+
+		// Twitter handles are often written with the `@`
+		name := strings.TrimPrefix(strings.TrimSpace(r.PostForm.Get("Name")), "@")
+		back := "/p/" + p.Name + "/"
+
+		if r.PostForm.Get("Action") == "remove" {
+			err := DelMember(db, *p, name)
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+			http.Redirect(w, r, back, http.StatusFound)
+			return
+		}
+
+		if name == "" || name == p.UserName {
+			http.Redirect(w, r, back, http.StatusFound)
+			return
+		}
+
+		// they must have signed in at least once
+		member, err := GetUser(db, name)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		if member.Name == "" {
+			renderError(w, r, &AppError{Status: http.StatusBadRequest, Message: "Sorry, @" + name + " hasn't signed in to the Week Project yet."})
+			return
+		}
 
-		// try and retrieve this project from the store
-		p, err := GetProject(db, user.Name, projectName)
+		err = AddMember(db, *p, member.Name)
 		if err != nil {
-			log.Printf("/p/{projectName}/ : err GetProject : %v\n", err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			renderError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, back, http.StatusFound)
+	})))
+
+	// Add or remove a goal.
+	p.Post("/p/{projectName}/goals", requireUser(loadProject(db, (*Project).CanEdit, func(w http.ResponseWriter, r *http.Request) {
+		p := projectFor(r)
+
+		errParseForm := r.ParseForm()
+		if errParseForm != nil {
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errParseForm))
+			return
+		}
+
+		back := "/p/" + p.Name + "/#goals"
+
+		if r.PostForm.Get("Action") == "remove" {
+			err := DelGoal(db, *p, r.PostForm.Get("Id"))
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+			http.Redirect(w, r, back, http.StatusFound)
+			return
+		}
+
+		day, _ := strconv.Atoi(r.PostForm.Get("Day"))
+		g, ok := p.NewGoal(r.PostForm.Get("Title"), day)
+		if !ok {
+			renderError(w, r, &AppError{Status: http.StatusBadRequest, Message: "Sorry, " + p.Error["Goal"] + "."})
 			return
 		}
-		if p.Name == "" {
-			log.Printf("/p/{projectName}/ : Not Found\n")
-			http.NotFound(w, r)
+
+		err := InsGoal(db, *p, g)
+		if err != nil {
+			renderError(w, r, err)
 			return
 		}
 
+		http.Redirect(w, r, back, http.StatusFound)
+	})))
+
+	// Specific Project
+	p.Get("/p/{projectName}/", requireUser(loadProject(db, (*Project).CanEdit, func(w http.ResponseWriter, r *http.Request) {
+		p := projectFor(r)
+
 		// get a list of updates
-		updates, err := SelUpdates(db, user.Name, projectName)
+		updates, bad, err := SelUpdates(db, p.UserName, p.Name)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		logBadRecords(logFor(r), bad)
+
+		// which updates they've given kudos to
+		kudos, err := SelKudosBy(db, *p, p.UserName)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		// what's been cross-posted
+		socialPosts, bad, err := SelSocialPosts(db, p.UserName, p.Name)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			renderError(w, r, err)
 			return
 		}
+		logBadRecords(logFor(r), bad)
 
 		data := struct {
-			Title    string
-			SubTitle string
-			User     *User
-			Project  Project
-			Updates  []*Update
+			Title        string
+			SubTitle     string
+			User         *User
+			Project      *Project
+			Updates      []*Update
+			Contributors []Contributor
+			Kudos        map[string]bool
+			SocialPosts  []*SocialPost
+			BaseUrl      string // for the embed codes
 		}{
 			p.Title,
 			"by @" + p.UserName,
-			user,
+			userFor(r),
 			p,
 			updates,
+			p.Contributors(updates),
+			kudos,
+			socialPosts,
+			baseUrl,
 		}
 		render(w, "p-project.html", data)
-	})
+	})))
 
 	// Projects
-	p.Get("/p/", func(w http.ResponseWriter, r *http.Request) {
+	p.Get("/p/", requireUser(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/p/" {
-			http.NotFound(w, r)
+			renderError(w, r, errNotFound)
 			return
 		}
 
-		// firstly, check if this user is logged in
-		session, _ := sessionStore.Get(r, sessionName)
-		user := getUserFromSession(session)
-		if user == nil {
-			http.Redirect(w, r, "/", http.StatusFound)
-			return
-		}
+		user := userFor(r)
 
 		// get a list of projects
-		projects, err := SelProjects(db, user.Name)
+		projects, bad, err := SelProjects(db, user.Name)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			renderError(w, r, err)
 			return
 		}
+		logBadRecords(logFor(r), bad)
 
-		fmt.Printf("projects=%#v\n", projects)
+		// and those they collaborate on
+		memberProjects, bad, err := SelMemberProjects(db, user.Name)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		logBadRecords(logFor(r), bad)
 
 		data := struct {
-			Title    string
-			SubTitle string
-			User     *User
-			Projects []*Project
+			Title          string
+			SubTitle       string
+			User           *User
+			Projects       []*Project
+			MemberProjects []*Project
 		}{
 			"Your Projects",
 			"",
 			user,
 			projects,
+			memberProjects,
 		}
 
 		render(w, "p.html", data)
-	})
+	}))
 
-	// home
-	p.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
+	// Delete a comment, either by the project owner or whoever wrote it. This must come before the path to add one.
+	p.Post("/u/{userName}/p/{projectName}/comment/{commentId}/delete", requireUser(loadProject(db, signedIn, func(w http.ResponseWriter, r *http.Request) {
+		p := projectFor(r)
+		user := userFor(r)
+
+		comment, err := GetComment(db, p.UserName, p.Name, r.URL.Query().Get(":commentId"))
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		if comment.Id == "" {
+			renderError(w, r, errNotFound)
+			return
+		}
+		if !comment.CanDelete(p, user) {
+			renderError(w, r, errForbidden)
+			return
+		}
+
+		err = DelComment(db, p.UserName, p.Name, comment.Id)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, viewUrl(p, r.URL.Query().Get("share"))+"#comments", http.StatusFound)
+	})))
+
+	// Comment on a public project, or one of it's updates.
+	commentLimiter := newRateLimiter(5, time.Minute)
+	p.Post("/u/{userName}/p/{projectName}/comment", requireUser(loadProject(db, signedIn, func(w http.ResponseWriter, r *http.Request) {
+		p := projectFor(r)
+		user := userFor(r)
+
+		share := r.URL.Query().Get("share")
+		if !p.CanView(user, share) {
+			renderError(w, r, errNotFound)
+			return
+		}
+		if p.CommentsDisabled {
+			renderError(w, r, &AppError{Status: http.StatusForbidden, Message: "Sorry, comments are turned off for this project."})
+			return
+		}
+
+		errParseForm := r.ParseForm()
+		if errParseForm != nil {
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errParseForm))
+			return
+		}
+
+		comment := Comment{}
+		errDecode := decoder.Decode(&comment, r.PostForm)
+		if errDecode != nil {
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errDecode))
+			return
+		}
+		comment.Author = user.Name
+
+		if comment.Validate() == false {
+			renderError(w, r, badRequest(comment.Error["Body"], nil))
+			return
+		}
+
+		if !commentLimiter.Allow(user.Name, comment.Inserted) {
+			renderError(w, r, &AppError{Status: http.StatusTooManyRequests, Message: "Sorry, you're commenting too quickly. Please wait a minute and try again."})
+			return
+		}
+
+		err := InsComment(db, *p, comment)
+		if err == ErrNoSuchUpdate {
+			renderError(w, r, badRequest("Sorry, we couldn't find that update.", err))
+			return
+		}
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		err = notifier.NewComment(*p, comment)
+		if err != nil {
+			logFor(r).Error("notifying new comment", "err", err)
+		}
+
+		http.Redirect(w, r, viewUrl(p, share)+"#comments", http.StatusFound)
+	})))
+
+	// A progress badge for READMEs and blogs. These must come before the public project page.
+	p.Get("/u/{userName}/p/{projectName}/badge.svg", func(w http.ResponseWriter, r *http.Request) {
+		p, err := getEmbeddable(db, r)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		svg, err := NewBadge(p).Svg()
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		serveEmbed(w, r, p, "badge.svg", svg)
+	})
+
+	// The image shown when a project is shared on social sites.
+	p.Get("/u/{userName}/p/{projectName}/preview.png", func(w http.ResponseWriter, r *http.Request) {
+		p, err := getEmbeddable(db, r)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		img, err := PreviewPng(p)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		serveEmbed(w, r, p, "preview.png", img)
+	})
+
+	// A widget showing the latest updates, to go in an iframe.
+	p.Get("/u/{userName}/p/{projectName}/widget", func(w http.ResponseWriter, r *http.Request) {
+		p, err := getEmbeddable(db, r)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		updates, bad, err := SelUpdates(db, p.UserName, p.Name)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		logBadRecords(logFor(r), bad)
+
+		// newest first
+		latest := make([]*Update, 0, widgetUpdates)
+		for i := len(updates) - 1; i >= 0 && len(latest) < widgetUpdates; i-- {
+			latest = append(latest, updates[i])
+		}
+
+		data := struct {
+			Project *Project
+			Updates []*Update
+			Url     string
+			Colour  string
+		}{
+			p,
+			latest,
+			p.ShareUrl(),
+			progressColour(p.Progress),
+		}
+
+		buf := &bytes.Buffer{}
+		err = tmpl.ExecuteTemplate(buf, "widget.html", data)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		serveEmbed(w, r, p, "widget.html", buf.Bytes())
+	})
+
+	// Publicly Viewable Projects, which must come after the more specific `/u/{userName}/p/{projectName}/...` paths
+	p.Get("/u/{userName}/p/{projectName}/", publicProject(db, baseUrl))
+
+	// Publicly Viewable Projects
+	p.Get("/u/{userName}/p/{projectName}", toSlash)
+
+	// Follow or unfollow a user.
+	p.Post("/u/{userName}/follow", requireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := userFor(r)
+		userName := r.URL.Query().Get(":userName")
+
+		if userName == user.Name {
+			renderError(w, r, badRequest("Sorry, you can't follow yourself.", nil))
+			return
+		}
+
+		followee, err := GetUser(db, userName)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		if followee.Name == "" {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		if r.PostFormValue("Action") == "unfollow" {
+			err = DelFollow(db, user.Name, followee.Name)
+		} else {
+			already, _ := IsFollowing(db, user.Name, followee.Name)
+			err = InsFollow(db, user.Name, followee.Name)
+			if err == nil && !already {
+				errNotify := notifier.NewFollower(user.Name, followee.Name)
+				if errNotify != nil {
+					logFor(r).Error("notifying new follower", "err", errNotify)
+				}
+			}
+		}
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/u/"+followee.Name+"/", http.StatusFound)
+	}))
+
+	// Public Profile, which must come after all the other `/u/{userName}/...` paths
+	p.Get("/u/{userName}/", func(w http.ResponseWriter, r *http.Request) {
+		userName := r.URL.Query().Get(":userName")
+		if r.URL.Path != "/u/"+userName+"/" {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		profile, err := GetUser(db, userName)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		if profile.Name == "" {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		// only their public projects are listed here
+		all, bad, err := SelProjects(db, userName)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		logBadRecords(logFor(r), bad)
+		projects := make([]*Project, 0, len(all))
+		for _, p := range all {
+			if p.IsPublic() {
+				projects = append(projects, p)
+			}
+		}
+
+		followers, following, err := GetFollowCounts(db, userName)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		session, _ := sessionStore.Get(r, sessionName)
+		user := getUserFromSession(session)
+
+		isFollowing := false
+		if user != nil {
+			isFollowing, err = IsFollowing(db, user.Name, userName)
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+		}
+
+		data := struct {
+			Title       string
+			SubTitle    string
+			User        *User
+			Profile     User
+			Projects    []*Project
+			Followers   int
+			Following   int
+			IsFollowing bool
+			Meta        *Meta
+		}{
+			"@" + profile.Name,
+			profile.Title,
+			user,
+			profile,
+			projects,
+			followers,
+			following,
+			isFollowing,
+			&Meta{
+				Title:       "@" + profile.Name + " on " + siteName,
+				Description: "Week projects by @" + profile.Name + ", and how they're getting on.",
+				Url:         baseUrl + "/u/" + profile.Name + "/",
+				Type:        "profile",
+			},
+		}
+		render(w, "u-user.html", data)
+	})
+
+	// Public Profile
+	p.Get("/u/{userName}", toSlash)
+
+	// Projects with a tag
+	p.Get("/t/{tag}/", func(w http.ResponseWriter, r *http.Request) {
+		tag := r.URL.Query().Get(":tag")
+		if r.URL.Path != "/t/"+tag+"/" {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		// send anyone who typed the tag in by hand to it's normalised page
+		if slug := slugify.Slugify(tag); slug != tag {
+			if slug == "" {
+				renderError(w, r, errNotFound)
+				return
+			}
+			http.Redirect(w, r, "/t/"+slug+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		session, _ := sessionStore.Get(r, sessionName)
+		user := getUserFromSession(session)
+
+		projects, bad, err := SelTagProjects(db, tag)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		logBadRecords(logFor(r), bad)
+
+		data := struct {
+			Title    string
+			SubTitle string
+			User     *User
+			Tag      string
+			Projects []*Project
+			Meta     *Meta
+		}{
+			"#" + tag,
+			"",
+			user,
+			tag,
+			projects,
+			&Meta{
+				Title:       "#" + tag + " on " + siteName,
+				Description: "Week projects about #" + tag + ".",
+				Url:         baseUrl + "/t/" + tag + "/",
+				Type:        "website",
+			},
+		}
+		render(w, "t-tag.html", data)
+	})
+
+	p.Get("/t/{tag}", toSlash)
+
+	// Popular tags, which must come after the `/t/{tag}/` paths
+	p.Get("/t/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/t/" {
+			renderError(w, r, errNotFound)
 			return
 		}
 
 		session, _ := sessionStore.Get(r, sessionName)
 		user := getUserFromSession(session)
 
+		cloud, err := SelTagCloud(db, time.Now().UTC().Add(-tagCloudPeriod), tagCloudLimit)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
 		data := struct {
 			Title    string
 			SubTitle string
 			User     *User
+			TagCloud []TagCount
+		}{
+			"Topics",
+			"",
+			user,
+			cloud,
+		}
+		render(w, "t.html", data)
+	})
+
+	// tag autocomplete
+	p.Get("/api/tags", func(w http.ResponseWriter, r *http.Request) {
+		tags, err := SelTagsByPrefix(db, slugify.Slugify(r.URL.Query().Get("q")), 10)
+		if err != nil {
+			logFor(r).Error("selecting tags", "err", err)
+			http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+			return
+		}
+
+		names := make([]string, 0, len(tags))
+		for _, t := range tags {
+			names = append(names, t.Tag)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(names)
+	})
+
+	// Dashboard, with recent updates from everyone this user follows
+	p.Get("/dashboard", requireUser(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dashboard" {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		user := userFor(r)
+
+		following, err := SelFollowing(db, user.Name)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		items, bad, err := SelFeed(db, following, time.Now().Add(-dashboardPeriod), dashboardLimit)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		logBadRecords(logFor(r), bad)
+
+		data := struct {
+			Title     string
+			SubTitle  string
+			User      *User
+			Following []string
+			Items     []*FeedItem
+		}{
+			"Dashboard",
+			"",
+			user,
+			following,
+			items,
+		}
+		render(w, "dashboard.html", data)
+	}))
+
+	// Notification Settings
+	p.Get("/settings", requireUser(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/settings" {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		user := userFor(r)
+		prefs, err := GetPrefs(db, user.Name)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		data := struct {
+			Title    string
+			SubTitle string
+			User     *User
+			Prefs    Prefs
+			Saved    bool
+		}{
+			"Settings",
+			"",
+			user,
+			prefs,
+			r.URL.Query().Get("saved") != "",
+		}
+		render(w, "settings.html", data)
+	}))
+
+	p.Post("/settings", requireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := userFor(r)
+
+		errParseForm := r.ParseForm()
+		if errParseForm != nil {
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errParseForm))
+			return
+		}
+
+		// decode into empty prefs, since an unticked checkbox isn't sent at all
+		prefs := Prefs{}
+		errDecode := decoder.Decode(&prefs, r.PostForm)
+		if errDecode != nil {
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errDecode))
+			return
+		}
+
+		// but keep the nudge secret, so their webhook can still check the signature
+		old, err := GetPrefs(db, user.Name)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		prefs.NudgeSecret = old.NudgeSecret
+
+		if prefs.Validate() == false {
+			data := struct {
+				Title    string
+				SubTitle string
+				User     *User
+				Prefs    Prefs
+				Saved    bool
+			}{
+				"Settings",
+				"",
+				user,
+				prefs,
+				false,
+			}
+			render(w, "settings.html", data)
+			return
+		}
+
+		err = PutPrefs(db, user.Name, prefs)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/settings?saved=1", http.StatusFound)
+	}))
+
+	// Webhooks, with the more specific paths first
+	p.Post("/webhooks/{webhookId}/delete", requireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := userFor(r)
+
+		err := DelWebhook(db, user.Name, r.URL.Query().Get(":webhookId"))
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/webhooks", http.StatusFound)
+	}))
+
+	p.Post("/webhooks/{webhookId}/test", requireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := userFor(r)
+
+		wh, err := GetWebhook(db, user.Name, r.URL.Query().Get(":webhookId"))
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		if wh.Id == "" {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		// a ping is about one of their projects, or a made up one if they don't have any yet
+		project := Project{UserName: user.Name, Name: "example", Title: "Example Project"}
+		if wh.ProjectName != "" {
+			project, err = GetProject(db, user.Name, wh.ProjectName)
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+		}
+
+		_, err = notifier.SendTest(&wh, project)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/webhooks#deliveries", http.StatusFound)
+	}))
+
+	renderWebhooks := func(w http.ResponseWriter, r *http.Request, webhook *Webhook) {
+		user := userFor(r)
+
+		webhooks, bad, err := SelWebhooks(db, user.Name)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		logBadRecords(logFor(r), bad)
+		projects, bad, err := SelProjects(db, user.Name)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		logBadRecords(logFor(r), bad)
+		deliveries, bad, err := SelDeliveries(db, user.Name)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		logBadRecords(logFor(r), bad)
+
+		data := struct {
+			Title      string
+			SubTitle   string
+			User       *User
+			Webhooks   []*Webhook
+			Webhook    *Webhook
+			Events     []string
+			Projects   []*Project
+			Deliveries []*Delivery
+		}{
+			"Webhooks",
+			"",
+			user,
+			webhooks,
+			webhook,
+			WebhookEvents,
+			projects,
+			deliveries,
+		}
+		render(w, "webhooks.html", data)
+	}
+
+	p.Get("/webhooks", requireUser(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/webhooks" {
+			renderError(w, r, errNotFound)
+			return
+		}
+		renderWebhooks(w, r, &Webhook{Events: WebhookEvents})
+	}))
+
+	p.Post("/webhooks", requireUser(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/webhooks" {
+			renderError(w, r, errNotFound)
+			return
+		}
+		user := userFor(r)
+
+		errParseForm := r.ParseForm()
+		if errParseForm != nil {
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errParseForm))
+			return
+		}
+
+		webhook := Webhook{}
+		errDecode := decoder.Decode(&webhook, r.PostForm)
+		if errDecode != nil {
+			renderError(w, r, badRequest("Sorry, we couldn't read that form.", errDecode))
+			return
+		}
+		webhook.UserName = user.Name
+
+		if webhook.ProjectName != "" {
+			p, err := GetProject(db, user.Name, webhook.ProjectName)
+			if err != nil {
+				renderError(w, r, err)
+				return
+			}
+			if p.Name == "" {
+				renderError(w, r, badRequest("Sorry, we couldn't find that project.", nil))
+				return
+			}
+		}
+
+		if webhook.Validate() == false {
+			renderWebhooks(w, r, &webhook)
+			return
+		}
+
+		err := InsWebhook(db, webhook)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		http.Redirect(w, r, "/webhooks", http.StatusFound)
+	}))
+
+	// Search, as a page and as JSON
+	p.Get("/search", func(w http.ResponseWriter, r *http.Request) {
+		session, _ := sessionStore.Get(r, sessionName)
+		user := getUserFromSession(session)
+
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		results, err := SelSearch(db, q, user, time.Now().UTC())
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+
+		data := struct {
+			Title    string
+			SubTitle string
+			User     *User
+			Query    string
+			Results  []*SearchResult
+		}{
+			"Search",
+			"",
+			user,
+			q,
+			results,
+		}
+		render(w, "search.html", data)
+	})
+
+	p.Get("/api/search", func(w http.ResponseWriter, r *http.Request) {
+		session, _ := sessionStore.Get(r, sessionName)
+		user := getUserFromSession(session)
+
+		q := strings.TrimSpace(r.URL.Query().Get("q"))
+		results, err := SelSearch(db, q, user, time.Now().UTC())
+		if err != nil {
+			logFor(r).Error("searching", "err", err)
+			http.Error(w, `{"error":"internal error"}`, http.StatusInternalServerError)
+			return
+		}
+
+		type jsonResult struct {
+			Project WebhookProject `json:"project"`
+			Update  *WebhookUpdate `json:"update,omitempty"`
+			Score   float64        `json:"score"`
+		}
+		out := struct {
+			Query   string       `json:"query"`
+			Results []jsonResult `json:"results"`
+		}{q, make([]jsonResult, 0, len(results))}
+		for _, result := range results {
+			p := result.Project
+			jr := jsonResult{
+				Project: WebhookProject{UserName: p.UserName, Name: p.Name, Title: p.Title, Progress: p.Progress, Url: baseUrl + p.Url()},
+				Score:   result.Score,
+			}
+			if u := result.Update; u != nil {
+				jr.Update = &WebhookUpdate{Id: u.Id, Author: u.Author, Status: u.Status, Progress: u.Progress}
+			}
+			out.Results = append(out.Results, jr)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(out)
+	})
+
+	// metrics, for whoever has METRICS_TOKEN
+	p.Get("/metrics", metricsHandler(db, os.Getenv("METRICS_TOKEN")))
+
+	// For search engines. The shards must come before the index, since routes match by prefix.
+	p.Get("/sitemap-{n:[0-9]+}.xml", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get(":n"))
+		shards, err := sitemapShards(db)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		if n < 1 || n > shards || r.URL.Path != fmt.Sprintf("/sitemap-%d.xml", n) {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		sitemap, err := SelSitemap(db, baseUrl, n)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		writeXml(w, r, sitemap)
+	})
+
+	p.Get("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sitemap.xml" {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		shards, err := sitemapShards(db)
+		if err != nil {
+			renderError(w, r, err)
+			return
+		}
+		writeXml(w, r, NewSitemapIndex(baseUrl, shards))
+	})
+
+	p.Get("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(sitemapMaxAge.Seconds())))
+		fmt.Fprint(w, robotsTxt(baseUrl))
+	})
+
+	// health checks
+	p.Get("/healthz", healthz)
+	p.Get("/readyz", readyz(db))
+
+	// home
+	p.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			renderError(w, r, errNotFound)
+			return
+		}
+
+		session, _ := sessionStore.Get(r, sessionName)
+		user := getUserFromSession(session)
+
+		cloud, err := SelTagCloud(db, time.Now().UTC().Add(-tagCloudPeriod), tagCloudLimit)
+		if err != nil {
+			logFor(r).Error("selecting tag cloud", "err", err)
+		}
+
+		data := struct {
+			Title    string
+			SubTitle string
+			User     *User
+			TagCloud []TagCount
+			Meta     *Meta
 		}{
 			"The Week Project",
 			"",
 			user,
+			cloud,
+			&Meta{Title: siteName, Description: siteDescription, Url: baseUrl + "/", Type: "website"},
 		}
 
 		render(w, "index.html", data)
 	})
 
-	// create the logger middleware
-	log := logger.New()
+	// server, with request ids and access logging
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: requestId(recoverer(p)),
+	}
+
+	go func() {
+		errServer := srv.ListenAndServe()
+		if errServer != http.ErrServerClosed {
+			check(errServer)
+		}
+	}()
+	slog.Info("listening", "port", port)
+
+	// the notifier runs until we shut down
+	notifyCtx, stopNotifier := context.WithCancel(context.Background())
+	notifierDone := make(chan struct{})
+	go func() {
+		notifier.Run(notifyCtx)
+		close(notifierDone)
+	}()
+
+	// wait for a signal, then let in-flight requests finish before closing the store
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	sig := <-stop
+	slog.Info("shutting down", "signal", sig.String())
+	draining.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	errShutdown := srv.Shutdown(ctx)
+	if errShutdown != nil {
+		slog.Error("shutting down server", "err", errShutdown)
+	}
+
+	stopNotifier()
+	<-notifierDone
 
-	// server
-	errServer := http.ListenAndServe(":"+port, log(p))
-	check(errServer)
+	errClose := db.Close()
+	if errClose != nil {
+		slog.Error("closing store", "err", errClose)
+	}
+	slog.Info("stopped")
 }
@@ -5,6 +5,11 @@
   var slider = document.getElementById('slider')
   console.log(slider)
 
+  // not every page has one, e.g. when progress is worked out from the goals
+  if (!slider) {
+    return
+  }
+
   // the place to display the percentage
   var display = document.getElementById('percentage')
   display.textContent = slider.value + '%'
@@ -0,0 +1,43 @@
+// Autocomplete for the comma separated tags input, using a <datalist> filled from /api/tags. Each suggestion is the
+// whole input with the tag being typed completed, since a datalist replaces the whole value.
+(function () {
+
+  var input = document.getElementById('tags')
+  var list = document.getElementById('tag-suggestions')
+  if (!input || !list) {
+    return
+  }
+
+  var last = ''
+
+  input.oninput = function() {
+    var parts = input.value.split(',')
+    var typing = parts.pop().trim()
+    if (typing === last) {
+      return
+    }
+    last = typing
+
+    list.innerHTML = ''
+    if (typing === '') {
+      return
+    }
+
+    var before = parts.map(function(p) { return p.trim() }).filter(function(p) { return p !== '' })
+
+    var xhr = new XMLHttpRequest()
+    xhr.open('GET', '/api/tags?q=' + encodeURIComponent(typing))
+    xhr.onload = function() {
+      if (xhr.status !== 200 || typing !== last) {
+        return
+      }
+      JSON.parse(xhr.responseText).forEach(function(tag) {
+        var option = document.createElement('option')
+        option.value = before.concat([tag]).join(', ')
+        list.appendChild(option)
+      })
+    }
+    xhr.send()
+  }
+
+})()